@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"cmp"
+	"slices"
+)
+
+// MostRecentlyUsed returns up to n keys of live entries, most recently
+// written or hit first, without promoting anything. It needs
+// WithAccessTracking and returns nil without it. It sorts every entry while
+// holding the read lock, in O(m log m) time for m entries, so it is meant for
+// debugging and occasional inspection rather than hot paths.
+func (c *BoundedCache[V]) MostRecentlyUsed(n int) []string {
+	if n <= 0 || !c.cfg.AccessTracking {
+		return nil
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	recent := make([]entryAt[V], 0, c.freshItems.len()+c.staleItems.len())
+	c.rangeLocked(func(key string, e entry[V]) bool {
+		recent = append(recent, entryAt[V]{key: key, e: e})
+		return true
+	})
+	slices.SortFunc(recent, func(a, b entryAt[V]) int {
+		return cmp.Compare(b.e.tick, a.e.tick)
+	})
+	keys := make([]string, 0, min(n, len(recent)))
+	for _, r := range recent[:min(n, len(recent))] {
+		keys = append(keys, r.key)
+	}
+	return keys
+}
+
+// HitCount returns how many times Get and the GetOrCreate family have hit
+// the entry under key since it was last written; promotion keeps the count
+// and a new write of key restarts it from zero. ok is false if key is absent.
+// It needs WithHitCounting and reports zero hits without it.
+func (c *BoundedCache[V]) HitCount(key string) (hits uint64, ok bool) {
+	key = c.foldKey(key)
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	e, _, ok := c.lookup(key)
+	return e.hits, ok
+}
@@ -0,0 +1,55 @@
+// Package boundedcachetest provides assertions for tests of code built on
+// BoundedCache, checking which generation holds each key without disturbing
+// the cache under test.
+package boundedcachetest
+
+import (
+	"testing"
+
+	cache "github.com/ryderlewis/boundedcache"
+)
+
+// AssertFresh reports an error for each of keys that is not held in c's
+// fresh generation. Lookups go through Peek, so nothing is promoted and the
+// cache's state is the same afterwards.
+func AssertFresh[V any](t testing.TB, c cache.Reader[V], keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if got := placement(c, key); got != "fresh" {
+			t.Errorf("key %q is %s, want fresh", key, got)
+		}
+	}
+}
+
+// AssertStale reports an error for each of keys that is not held in c's
+// stale generation, without promoting anything.
+func AssertStale[V any](t testing.TB, c cache.Reader[V], keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if got := placement(c, key); got != "stale" {
+			t.Errorf("key %q is %s, want stale", key, got)
+		}
+	}
+}
+
+// AssertAbsent reports an error for each of keys that c holds in either
+// generation, without promoting anything.
+func AssertAbsent[V any](t testing.TB, c cache.Reader[V], keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if got := placement(c, key); got != "absent" {
+			t.Errorf("key %q is %s, want absent", key, got)
+		}
+	}
+}
+
+// placement describes where c holds key.
+func placement[V any](c cache.Reader[V], key string) string {
+	switch _, ok, stale := c.Peek(key); {
+	case !ok:
+		return "absent"
+	case stale:
+		return "stale"
+	}
+	return "fresh"
+}
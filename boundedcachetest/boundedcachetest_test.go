@@ -0,0 +1,36 @@
+package boundedcachetest
+
+import (
+	"testing"
+
+	cache "github.com/ryderlewis/boundedcache"
+)
+
+// recorder is a testing.TB that records failures instead of failing.
+type recorder struct {
+	testing.TB
+	errors int
+}
+
+func (r *recorder) Helper()               {}
+func (r *recorder) Errorf(string, ...any) { r.errors++ }
+
+func TestAssertions(t *testing.T) {
+	c := cache.NewBoundedCache[int](4)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+
+	AssertFresh(t, c, "c")
+	AssertStale(t, c, "a", "b")
+	AssertAbsent(t, c, "x")
+	AssertStale(t, c, "a") // checking did not promote a
+
+	r := &recorder{TB: t}
+	AssertFresh(r, c, "a", "x")
+	AssertStale(r, c, "c")
+	AssertAbsent(r, c, "b")
+	if r.errors != 4 {
+		t.Fatalf("%d failures reported, want 4", r.errors)
+	}
+}
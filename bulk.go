@@ -0,0 +1,100 @@
+package cache
+
+// BulkLoad lets fn store a batch of entries without the batch evicting
+// itself. Entries passed to set are staged outside the cache and installed
+// atomically once fn returns, so other goroutines keep seeing the previous
+// contents until then. If the batch and the existing entries together exceed
+// MaxItems, the most recently set entries are kept: later set calls win over
+// earlier ones, and every batch entry wins over pre-existing entries. The
+// newest entries land in the fresh generation and the rest in the stale one.
+//
+// The staged batch is held in full until fn returns, so a load larger than
+// MaxItems transiently uses memory proportional to the whole batch.
+func (c *BoundedCache[V]) BulkLoad(fn func(set func(key string, val V))) {
+	staged := make(map[string]V)
+	var order []string // set order; a key repeats if set more than once
+	fn(func(key string, val V) {
+		if c.rejects(val) {
+			return
+		}
+		key = c.foldKey(key)
+		staged[key] = val
+		order = append(order, key)
+	})
+
+	// Walk the batch newest first so that each key is placed by its last set.
+	batch := make([]string, 0, len(staged))
+	placed := make(map[string]bool, len(staged))
+	for i := len(order) - 1; i >= 0; i-- {
+		if key := order[i]; !placed[key] {
+			placed[key] = true
+			batch = append(batch, key)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	oldFresh, oldStale := c.freshItems, c.staleItems
+	c.freshItems = c.makeStore(c.maxItemMapLen)
+	c.staleItems = c.makeStore(0)
+	place := func(key string, e entry[V]) bool {
+		if c.freshItems.len() < c.maxItemMapLen {
+			c.freshItems.set(key, e)
+		} else if !c.cfg.SingleGeneration && c.staleItems.len() < c.maxItemMapLen {
+			c.staleItems.set(key, e)
+		} else {
+			return false
+		}
+		return true
+	}
+	for _, key := range batch {
+		if c.negative != nil {
+			delete(c.negative, key)
+		}
+		e := c.newEntry(staged[key])
+		if c.cfg.MaxLifetime > 0 {
+			// Keep the creation time of a live entry the batch replaces.
+			for _, old := range []store[V]{oldFresh, oldStale} {
+				if prev, ok := old.get(key); ok && !c.expired(prev) {
+					e.created = prev.created
+				}
+			}
+		}
+		if place(key, e) && len(c.waiters) > 0 {
+			c.notifyWaiters(key)
+		}
+	}
+	for _, old := range []store[V]{oldFresh, oldStale} {
+		for key, e := range old.all() {
+			switch {
+			case placed[key]:
+				if c.onEvict != nil {
+					c.onEvict(key, e.value, ReasonReplace)
+				}
+			case c.expired(e):
+				c.expire(key, e.value)
+			case !place(key, e):
+				c.discard(key, e.value)
+			}
+		}
+	}
+
+	if c.cfg.OrderedIteration {
+		c.pruneOrder()
+		inOrder := make(map[string]bool, len(c.order))
+		for _, key := range c.order {
+			inOrder[key] = true
+		}
+		for i := len(batch) - 1; i >= 0; i-- {
+			if key := batch[i]; !inOrder[key] {
+				if _, _, ok := c.rawLookup(key); ok {
+					c.order = append(c.order, key)
+				}
+			}
+		}
+	}
+	if c.onHighWater != nil {
+		c.checkWatermark()
+	}
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+)
+
+// ByteCache is a BoundedCache of byte slices with helpers for values that
+// are produced as a stream.
+type ByteCache struct {
+	*BoundedCache[[]byte]
+}
+
+// NewByteCache returns a ByteCache that holds at most maxItems entries.
+func NewByteCache(maxItems int, opts ...Option[[]byte]) *ByteCache {
+	return &ByteCache{NewBoundedCache(maxItems, opts...)}
+}
+
+// GetOrCreateStream returns the bytes cached under key, promoting them if
+// stale. On a miss it calls produce, off-lock, with a buffer to write the
+// value to, caches the buffered bytes and returns them. If produce fails its
+// output is discarded and the error is returned. Bytes that were already
+// cached, including those another writer stored while produce ran, are
+// returned through WithCopyOnRead like any other read; freshly produced
+// bytes are not, so callers must not modify the returned slice, which may be
+// shared with the cache.
+func (c *ByteCache) GetOrCreateStream(key string, produce func(io.Writer) error) ([]byte, error) {
+	key = c.foldKey(key)
+	if val, ok, _ := c.Get(key); ok {
+		return val, nil
+	}
+	var buf bytes.Buffer
+	var err error
+	c.runCreate(key, true, func() { err = produce(&buf) })
+	if err != nil {
+		return nil, err
+	}
+	val, found, _ := c.storeCreated(key, buf.Bytes())
+	if found {
+		val = c.copyOut(val)
+	}
+	return val, nil
+}
@@ -1,4 +1,2145 @@
 package cache
 
+import (
+	"cmp"
+	"errors"
+	"iter"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
 type RateLimitCache struct {
 }
+
+// BoundedCache is a concurrency-safe cache that holds at most MaxItems
+// entries. Entries live in one of two generations: a fresh map that receives
+// every insert, and a stale map holding the previous generation. When the
+// fresh map reaches its cap it becomes the stale map and the old stale map is
+// discarded wholesale. A lookup that hits the stale map promotes the entry
+// back into the fresh map, giving recently used entries a second chance.
+type BoundedCache[V any] struct {
+	lock          rwLocker
+	maxItemMapLen int
+	freshItems    store[V]
+	staleItems    store[V]
+	// storeFactory creates the stores backing the generations. When nil,
+	// generations are mapStores.
+	storeFactory func(capacity int) store[V]
+	version      uint64
+	cfg          Config
+	// unlocked is set for caches from NewUnsafeBoundedCache, whose lock does
+	// nothing.
+	unlocked bool
+	// accessClock is bumped on every write and hit under WithAccessTracking.
+	accessClock uint64
+	// writeOnHit is set when hits update their entry, for idle timeouts,
+	// access tracking or hit counting, so that Get cannot serve them under
+	// the read lock.
+	writeOnHit bool
+
+	onHighWater    func()
+	aboveWatermark bool
+	onEvent        func(Event)
+
+	// order holds live keys in insertion order when OrderedIteration is
+	// enabled.
+	order []string
+
+	now func() time.Time
+	// negative maps keys whose creation found nothing to the time that
+	// result expires. It is nil unless NegativeTTL is set.
+	negative map[string]time.Time
+
+	sizeEstimator func(key string, value V) int64
+
+	generation atomic.Uint64
+	// freshHits and staleHits count hits served by each generation, and
+	// misses the lookups that found nothing.
+	freshHits atomic.Uint64
+	staleHits atomic.Uint64
+	misses    atomic.Uint64
+
+	// ghosts remembers recently evicted keys under WithChurnTracking, and
+	// churn counts the keys that were stored again while remembered.
+	ghosts *ghostSet
+	churn  atomic.Uint64
+
+	// waiters holds the goroutines blocked in WaitFor, by key.
+	waiters map[string]*waiter
+	// creating holds the creations started by GetOrCreateWait, by key.
+	creating map[string]*createCall[V]
+
+	// evictions rations the entries shifts may discard under
+	// WithEvictionRateLimit; nil means unlimited.
+	evictions *evictionBudget
+
+	onEvict        func(key string, value V, reason EvictReason)
+	evictionFilter func(key string, value V) bool
+	healthCheck    func(V) bool
+	onPromote      func(key string, value V)
+	onExpire       func(key string, value V)
+	victim         Cache[V]
+	fallback       Reader[V]
+	copyOnRead     func(V) V
+	copyOnPromote  func(V) V
+	defaultCreate  func(key string) V
+	changeDetector func(old, new V) bool
+	underPressure  func() bool
+	onSlowCreate   func(key string, start time.Time, took time.Duration)
+
+	// createSlots is the semaphore behind WithMaxConcurrentCreates, nil
+	// without a limit, and createLimitHits counts creations that found it
+	// full.
+	createSlots     chan struct{}
+	createLimitHits atomic.Uint64
+	// teardownOrder returns the keys of a purge in the order set by
+	// WithTeardownOrder.
+	teardownOrder func(keys []string, values []V) []string
+	// panicHandler receives panics recovered from the hooks above, as set
+	// by WithPanicHandler.
+	panicHandler func(recovered any)
+
+	// snapshot is an immutable copy of the fresh generation that Get reads
+	// without locking under WithLockFreeReads. Releasing the write lock
+	// clears it and the next reader rebuilds it.
+	snapshot   atomic.Pointer[map[string]entry[V]]
+	rebuilding atomic.Bool
+
+	// prioritized is set once any entry has been stored with a positive
+	// priority, and makes shift look for entries to carry over.
+	prioritized bool
+}
+
+// Cache is the method set shared by caches in this package, so that they
+// can be layered, for example as a victim cache.
+type Cache[V any] interface {
+	Add(key string, val V) (evicted bool)
+	Get(key string) (val V, ok bool, evicted bool)
+	GetOrCreate(key string, create func() V) (val V, found bool, evicted bool)
+	Peek(key string) (val V, ok bool, stale bool)
+	Remove(key string) bool
+	Len() int
+}
+
+var _ Cache[int] = (*BoundedCache[int])(nil)
+
+// Reader is a read-only source of values, consulted by a cache built with
+// WithFallback. Every Cache is a Reader; ReaderFunc adapts a plain lookup
+// function. Sources without generations report stale as false.
+type Reader[V any] interface {
+	Peek(key string) (val V, ok bool, stale bool)
+}
+
+// ReaderFunc adapts a lookup function to Reader.
+type ReaderFunc[V any] func(key string) (val V, ok bool)
+
+// Peek returns f(key).
+func (f ReaderFunc[V]) Peek(key string) (val V, ok bool, stale bool) {
+	val, ok = f(key)
+	return val, ok, false
+}
+
+// entry is a stored value together with the version assigned when it was
+// last written.
+type entry[V any] struct {
+	value   V
+	version uint64
+	// accessed is the UnixNano time of the last write or hit. It is only
+	// maintained when IdleTimeout is set.
+	accessed int64
+	// written is the UnixNano time of the write that stored the value. It is
+	// only maintained when IdleTimeout, HardTTL or MaxLifetime is set.
+	written int64
+	// priority is set by AddWithPriority; entries above zero may be carried
+	// over by a shift.
+	priority int
+	// deferred records that WithDeferredPromotion already left a stale hit
+	// on this entry in place.
+	deferred bool
+	// meta is the metadata stored by SetWithMeta.
+	meta map[string]any
+	// tick is the access clock reading at the last write or hit. It is only
+	// maintained when AccessTracking is set.
+	tick uint64
+	// hits counts the hits on the entry since it was written. It is only
+	// maintained when HitCounting is set.
+	hits uint64
+	// refreshAt is the UnixNano time set by SetUntil after which the entry
+	// needs a refresh, or zero.
+	refreshAt int64
+	// created is the UnixNano time the key was first stored, which
+	// overwrites and promotion keep. It is only maintained when MaxLifetime
+	// is set.
+	created int64
+}
+
+// entryAt is an entry together with its key.
+type entryAt[V any] struct {
+	key string
+	e   entry[V]
+}
+
+// rwLocker is the subset of sync.RWMutex the cache relies on.
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+// noLock is an rwLocker that does nothing, for caches confined to a single
+// goroutine.
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+// snapshotLock wraps the cache's lock under WithLockFreeReads. Releasing the
+// write lock discards the fresh-generation snapshot while writers are still
+// excluded, so no reader can observe a snapshot older than the last write.
+type snapshotLock struct {
+	rwLocker
+	invalidate func()
+}
+
+func (l snapshotLock) Unlock() {
+	l.invalidate()
+	l.rwLocker.Unlock()
+}
+
+// NewBoundedCache returns a cache that holds at most maxItems entries. Each
+// generation is capped at half of maxItems, with a minimum of one entry.
+func NewBoundedCache[V any](maxItems int, opts ...Option[V]) *BoundedCache[V] {
+	return newBoundedCache(maxItems, new(sync.RWMutex), opts)
+}
+
+// NewUnsafeBoundedCache returns a cache like NewBoundedCache that performs no
+// locking at all.
+//
+// The returned cache is NOT safe for concurrent use. Every method, including
+// the read-only ones, must be called from a single goroutine or under
+// external synchronization; concurrent use corrupts the cache. It exists for
+// single-goroutine hot paths, such as per-request caches, where the mutex is
+// pure overhead.
+func NewUnsafeBoundedCache[V any](maxItems int, opts ...Option[V]) *BoundedCache[V] {
+	return newBoundedCache[V](maxItems, noLock{}, opts)
+}
+
+func newBoundedCache[V any](maxItems int, lock rwLocker, opts []Option[V]) *BoundedCache[V] {
+	maxItemMapLen := maxItems / 2
+	if maxItemMapLen < 1 {
+		maxItemMapLen = 1
+	}
+	_, unlocked := lock.(noLock)
+	c := &BoundedCache[V]{
+		lock:          lock,
+		unlocked:      unlocked,
+		maxItemMapLen: maxItemMapLen,
+		cfg:           Config{LowWatermark: -1},
+		now:           time.Now,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.cfg.SingleGeneration {
+		c.maxItemMapLen = c.generationCap(maxItems)
+	}
+	c.cfg.MaxItems = c.maxItems()
+	c.writeOnHit = c.cfg.IdleTimeout > 0 || c.cfg.AccessTracking || c.cfg.HitCounting
+	c.cfg.InitialCapacity = min(max(c.cfg.InitialCapacity, 0), c.maxItemMapLen)
+	if c.cfg.LowWatermark < 0 || c.cfg.LowWatermark > c.cfg.HighWatermark {
+		c.cfg.LowWatermark = c.cfg.HighWatermark
+	}
+	c.freshItems = c.makeStore(c.cfg.InitialCapacity)
+	c.staleItems = c.makeStore(0)
+	if c.cfg.NegativeTTL > 0 {
+		c.negative = make(map[string]time.Time)
+	}
+	if c.cfg.ChurnTracking {
+		c.ghosts = &ghostSet{}
+	}
+	if c.cfg.MaxConcurrentCreates > 0 {
+		c.createSlots = make(chan struct{}, c.cfg.MaxConcurrentCreates)
+	}
+	if c.cfg.EvictionRateLimit > 0 {
+		c.evictions = newEvictionBudget(c.cfg.EvictionRateLimit, c.now())
+	}
+	if c.panicHandler != nil {
+		c.guardHooks()
+	}
+	if c.cfg.LockFreeReads {
+		c.lock = snapshotLock{rwLocker: c.lock, invalidate: func() { c.snapshot.Store(nil) }}
+	}
+	return c
+}
+
+// MaxItems returns the maximum number of entries the cache can hold.
+func (c *BoundedCache[V]) MaxItems() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.maxItems()
+}
+
+// FreshRatio returns the fraction of MaxItems allotted to the fresh
+// generation.
+func (c *BoundedCache[V]) FreshRatio() float64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return float64(c.maxItemMapLen) / float64(c.maxItems())
+}
+
+// Options returns a copy of the configuration the cache was built with, after
+// defaults and clamping were applied and updated by any Resize. Callback
+// options are not included.
+func (c *BoundedCache[V]) Options() Config {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cfg
+}
+
+// Resize changes the maximum number of entries the cache holds, with the
+// same rounding as NewBoundedCache, and then calls Rebalance so that the
+// current contents fit the new caps.
+func (c *BoundedCache[V]) Resize(maxItems int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxItemMapLen = c.generationCap(maxItems)
+	c.cfg.MaxItems = c.maxItems()
+	c.rebalance()
+}
+
+// Rebalance redistributes entries so that neither generation exceeds the
+// per-generation cap. If the cache holds more than MaxItems entries,
+// arbitrary stale entries are evicted first, and fresh ones only once the
+// stale generation is empty. Excess fresh entries are then demoted to stale,
+// and excess stale entries promoted into spare fresh capacity. Entries are
+// chosen arbitrarily within a generation. Resize calls Rebalance itself; it
+// only needs to be called directly after other changes to the caps.
+func (c *BoundedCache[V]) Rebalance() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.rebalance()
+}
+
+// FillRatio returns Len divided by MaxItems.
+func (c *BoundedCache[V]) FillRatio() float64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.fillRatio()
+}
+
+// Occupancy returns the sizes of the fresh and stale generations and the
+// per-generation cap from a single locked read.
+func (c *BoundedCache[V]) Occupancy() (fresh int, stale int, max int) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.freshItems.len(), c.staleItems.len(), c.maxItemMapLen
+}
+
+// StaleHitRatio returns the fraction of hits since the cache was created that
+// were served by the stale generation and promoted, rather than served by the
+// fresh one. Hits are counted by Get and the other promoting lookups; Peek
+// and the other read-only accessors do not count. A ratio near zero means the
+// stale generation rarely saves a miss and the cache could be smaller; a high
+// ratio means it is doing real work. It returns 0 before the first hit, and
+// always under WithStaleHitsAsMisses, which counts stale hits as misses.
+func (c *BoundedCache[V]) StaleHitRatio() float64 {
+	stale := c.staleHits.Load()
+	total := stale + c.freshHits.Load()
+	if total == 0 {
+		return 0
+	}
+	return float64(stale) / float64(total)
+}
+
+// Stats is a snapshot of a cache's lookup counters, as returned by
+// BoundedCache.Stats.
+type Stats struct {
+	// FreshHits counts hits served by the fresh generation.
+	FreshHits uint64
+	// StaleHits counts hits served by the stale generation, each of which
+	// promoted its entry.
+	StaleHits uint64
+	// Misses counts lookups that found nothing, and also stale hits under
+	// WithStaleHitsAsMisses.
+	Misses uint64
+}
+
+// Stats returns the lookup counters accumulated since the cache was created.
+// Lookups are counted by Get, the GetOrCreate family, and GetOrSet; Peek and
+// the other read-only accessors do not count. Under WithStaleHitsAsMisses
+// stale hits are counted in Misses and StaleHits stays zero. A lookup served
+// by the victim cache of WithVictimCache or the fallback of WithFallback is
+// not counted at all, as either a hit or a miss, so the counters describe
+// this cache's own generations. The counters
+// are read atomically without taking the lock, each on its own, so a
+// snapshot taken during concurrent lookups need not add up exactly.
+func (c *BoundedCache[V]) Stats() Stats {
+	return Stats{
+		FreshHits: c.freshHits.Load(),
+		StaleHits: c.staleHits.Load(),
+		Misses:    c.misses.Load(),
+	}
+}
+
+// HitRatio returns the fraction of counted lookups that hit, in either
+// generation, or only in the fresh one under WithStaleHitsAsMisses. It
+// returns 0, not NaN, when there have been no lookups.
+func (s Stats) HitRatio() float64 {
+	hits := s.FreshHits + s.StaleHits
+	if hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+s.Misses)
+}
+
+// HitRatio is shorthand for Stats().HitRatio().
+func (c *BoundedCache[V]) HitRatio() float64 {
+	return c.Stats().HitRatio()
+}
+
+// Len returns the number of entries currently held across both generations.
+// It is O(1), and with WithIdleTimeout, WithSoftTTL or WithMaxLifetime it
+// includes entries that have expired but have not been swept yet; LiveLen
+// excludes them.
+func (c *BoundedCache[V]) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.freshItems.len() + c.staleItems.len()
+}
+
+// LiveLen returns the number of entries that a lookup would find, leaving
+// out expired entries that are still held. It has to check every entry's
+// timestamp, so it is O(n) where Len is O(1); without WithIdleTimeout,
+// WithSoftTTL or WithMaxLifetime nothing expires and it returns Len.
+func (c *BoundedCache[V]) LiveLen() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	n := c.freshItems.len() + c.staleItems.len()
+	if !c.expiring() {
+		return n
+	}
+	for _, items := range []store[V]{c.freshItems, c.staleItems} {
+		for _, e := range items.all() {
+			if c.expired(e) {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+// EstimatedBytes approximates the heap footprint of the cached entries. Each
+// entry is charged the size of its map slot, including the map's spare
+// capacity, plus the bytes of its key, or whatever the estimator installed
+// with WithSizeEstimator reports for it. Memory referenced by values is only
+// counted through the estimator. The figure is a rough planning aid, not an
+// exact measurement, and walks every entry when an estimator is set.
+func (c *BoundedCache[V]) EstimatedBytes() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	n := int64(c.freshItems.len() + c.staleItems.len())
+	total := n * mapSlotBytes[V]()
+	c.rangeLocked(func(key string, e entry[V]) bool {
+		if c.sizeEstimator != nil {
+			total += c.sizeEstimator(key, e.value)
+		} else {
+			total += int64(len(key))
+		}
+		return true
+	})
+	return total
+}
+
+// mapSlotBytes approximates the bytes a Go map spends per stored entry: the
+// key header and entry inline, one control byte, and the headroom maps keep
+// below their maximum load factor of 7/8.
+func mapSlotBytes[V any]() int64 {
+	var (
+		key string
+		e   entry[V]
+	)
+	slot := int64(unsafe.Sizeof(key) + unsafe.Sizeof(e))
+	return slot*8/7 + 1
+}
+
+// Add stores val under key in the fresh generation, replacing any existing
+// value. It reports whether the insert caused stale entries to be evicted.
+func (c *BoundedCache[V]) Add(key string, val V) (evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.add(key, val)
+}
+
+// AddTo stores val under key in the stale generation if stale is true, and
+// otherwise behaves like Add. It is meant for restoring a snapshot in which
+// cold entries should stay first in line for eviction. A key is only ever
+// stored in one generation, so AddTo moves an existing entry if needed. The
+// stale generation is capped like the fresh one: when it is full, storing a
+// new key there discards one arbitrary stale entry, and evicted reports it.
+func (c *BoundedCache[V]) AddTo(key string, val V, stale bool) (evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !stale || c.cfg.SingleGeneration || c.rejects(val) {
+		return c.add(key, val)
+	}
+
+	e := c.newEntry(val)
+	c.inherit(key, &e)
+	if c.negative != nil {
+		delete(c.negative, key)
+	}
+	old, _, existed := c.rawLookup(key)
+	if existed {
+		c.freshItems.delete(key)
+		if c.onEvict != nil {
+			c.onEvict(key, old.value, ReasonReplace)
+		}
+	} else if c.staleItems.len() >= c.maxItemMapLen {
+		for victim, ve := range c.staleItems.all() {
+			c.staleItems.delete(victim)
+			c.discard(victim, ve.value)
+			break
+		}
+		evicted = true
+	}
+	if !existed && c.ghosts != nil && c.ghosts.take(key) {
+		c.churn.Add(1)
+	}
+	c.staleItems.set(key, e)
+	if len(c.waiters) > 0 {
+		c.notifyWaiters(key)
+	}
+	if c.cfg.OrderedIteration {
+		if evicted {
+			c.pruneOrder()
+		}
+		if !existed {
+			c.order = append(c.order, key)
+		}
+	}
+	if c.onHighWater != nil {
+		c.checkWatermark()
+	}
+	return evicted
+}
+
+// ErrNilValue is returned by TryAdd when WithRejectNil is set and the value
+// is nil.
+var ErrNilValue = errors.New("cache: nil value rejected")
+
+// AddWithPriority is like Add but stores val with the given priority. When a
+// shift discards the stale generation, stale entries with a priority above
+// zero are carried over into the new fresh generation, highest priority first
+// and ties in unspecified order, up to a budget of a quarter of the
+// generation cap (at least one entry, and never the slot needed by the
+// insert that triggered the shift); entries rescued by an eviction filter
+// use up room first. The rest are evicted as usual. Unlike a pin, priority is
+// only a preference: a low priority entry can outlive a high priority one, and
+// a prioritized entry that does not fit the budget is evicted. Any later
+// write of key through another method resets its priority to zero.
+func (c *BoundedCache[V]) AddWithPriority(key string, val V, priority int) (evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.addWithPriority(key, val, priority)
+}
+
+// TryAdd is like Add but reports ErrNilValue instead of silently refusing a
+// nil value when the cache was built with WithRejectNil.
+func (c *BoundedCache[V]) TryAdd(key string, val V) (evicted bool, err error) {
+	if c.rejects(val) {
+		return false, ErrNilValue
+	}
+	return c.Add(key, val), nil
+}
+
+// Get returns the value stored under key. A hit in the stale generation
+// promotes the entry to the fresh generation, which may evict stale entries;
+// evicted reports whether that happened.
+//
+// With WithIdleTimeout, WithAccessTracking or WithHitCounting every hit
+// records its access, so Get always takes the write lock.
+func (c *BoundedCache[V]) Get(key string) (val V, ok bool, evicted bool) {
+	val, ok, _, evicted = c.GetDetailed(key)
+	return val, ok, evicted
+}
+
+// GetDetailed is like Get but also reports whether the hit was promoted from
+// the stale generation to the fresh one, for telemetry that needs to tell
+// fresh hits, which change nothing, from promotions. promoted is false for
+// fresh hits, misses and stale hits held back by WithDeferredPromotion.
+func (c *BoundedCache[V]) GetDetailed(key string) (val V, ok bool, promoted bool, evicted bool) {
+	key = c.foldKey(key)
+	if !c.writeOnHit {
+		var e entry[V]
+		var ok bool
+		if c.cfg.LockFreeReads {
+			e, ok = c.snapshotGet(key)
+			ok = ok && !c.expired(e)
+		} else {
+			c.lock.RLock()
+			// Bypass the store interface for the default store: the
+			// indirect call costs more than the map access itself.
+			if m, isMap := c.freshItems.(mapStore[V]); isMap {
+				e, ok = m[key]
+			} else {
+				e, ok = c.freshItems.get(key)
+			}
+			ok = ok && !c.expired(e)
+			c.lock.RUnlock()
+		}
+		// The health check runs after the read lock is released, so that a
+		// panic in it cannot leave the lock held.
+		if ok && (c.healthCheck == nil || c.healthCheck(e.value)) {
+			c.freshHits.Add(1)
+			if c.onEvent != nil {
+				c.emit(EventHit, key)
+			}
+			return c.copyOut(e.value), true, false, false
+		}
+	}
+
+	val, ok, promoted, evicted = c.getExclusive(key)
+	if ok {
+		val = c.copyOut(val)
+	}
+	return val, ok, promoted, evicted
+}
+
+// snapshotGet looks key up in the fresh generation through the lock-free
+// snapshot. If a write has discarded the snapshot, it falls back to the read
+// lock, and one reader at a time rebuilds the snapshot while holding it.
+func (c *BoundedCache[V]) snapshotGet(key string) (entry[V], bool) {
+	if snap := c.snapshot.Load(); snap != nil {
+		e, ok := (*snap)[key]
+		return e, ok
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.rebuilding.CompareAndSwap(false, true) {
+		fresh := make(map[string]entry[V], c.freshItems.len())
+		for key, e := range c.freshItems.all() {
+			fresh[key] = e
+		}
+		c.snapshot.Store(&fresh)
+		c.rebuilding.Store(false)
+	}
+	e, ok := c.freshItems.get(key)
+	return e, ok
+}
+
+// getExclusive is Get's slow path, taken under the write lock.
+func (c *BoundedCache[V]) getExclusive(key string) (val V, ok bool, promoted bool, evicted bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	val, hit, promoted, evicted := c.getPromotingLocked(key)
+	c.countLookup(hit)
+	ok = hit != lookupMiss
+	if c.onEvent != nil {
+		c.emitLookup(key, ok)
+	}
+	return val, ok, promoted, evicted
+}
+
+// GetOrCreate returns the value stored under key, promoting it if stale. On a
+// miss it calls create without holding the lock and stores the result. If
+// another goroutine stored the key while create ran, that value wins and is
+// returned instead. found reports whether the returned value was already
+// cached. A nil create makes GetOrCreate behave like Get: a miss returns the
+// zero value and stores nothing.
+func (c *BoundedCache[V]) GetOrCreate(key string, create func() V) (val V, found bool, evicted bool) {
+	key = c.foldKey(key)
+	if create == nil {
+		return c.Get(key)
+	}
+	return c.getOrCreate(key, func() (V, bool) { return create(), true })
+}
+
+// Fetch is GetOrCreate with the creator configured by WithDefaultCreate. On
+// a miss it creates key's value off-lock and stores it. Without a default
+// creator Fetch behaves like Get and a miss creates nothing.
+func (c *BoundedCache[V]) Fetch(key string) (val V, found bool, evicted bool) {
+	key = c.foldKey(key)
+	if c.defaultCreate == nil {
+		return c.Get(key)
+	}
+	return c.getOrCreate(key, func() (V, bool) { return c.defaultCreate(key), true })
+}
+
+// ErrCreateBusy is returned by TryGetOrCreate when every creation slot
+// allowed by WithMaxConcurrentCreates is taken.
+var ErrCreateBusy = errors.New("cache: concurrent create limit reached")
+
+// TryGetOrCreate is like GetOrCreate, but on a miss when every slot allowed
+// by WithMaxConcurrentCreates is in use it returns ErrCreateBusy at once
+// instead of waiting for one, leaving the caller to shed or retry the
+// request. Without that option it never fails.
+func (c *BoundedCache[V]) TryGetOrCreate(key string, create func() V) (val V, found bool, evicted bool, err error) {
+	key = c.foldKey(key)
+	if create == nil {
+		val, found, evicted = c.Get(key)
+		return val, found, evicted, nil
+	}
+	if val, ok, evicted := c.Get(key); ok {
+		return val, true, evicted, nil
+	}
+	var created V
+	if !c.runCreate(key, false, func() { created = create() }) {
+		return val, false, false, ErrCreateBusy
+	}
+	val, found, evicted = c.storeCreated(key, created)
+	if found {
+		val = c.copyOut(val)
+	}
+	return val, found, evicted, nil
+}
+
+// CreateLimitHits returns how many creations found every slot allowed by
+// WithMaxConcurrentCreates taken, whether they then waited or, through
+// TryGetOrCreate, gave up. A steadily rising count means the limit is
+// throttling fills.
+func (c *BoundedCache[V]) CreateLimitHits() uint64 {
+	return c.createLimitHits.Load()
+}
+
+// GetOrCreateCond is like GetOrCreate, but create may veto caching its
+// result by returning cache=false. A vetoed value is still returned to the
+// caller; it is simply not stored, so the next lookup misses again.
+func (c *BoundedCache[V]) GetOrCreateCond(key string, create func() (val V, cache bool)) (V, bool, bool) {
+	key = c.foldKey(key)
+	if create == nil {
+		return c.Get(key)
+	}
+	return c.getOrCreate(key, create)
+}
+
+// GetOrCreateKey is like GetOrCreate but passes key to create, so one shared
+// creator can serve every key without a closure being built per call.
+func (c *BoundedCache[V]) GetOrCreateKey(key string, create func(key string) V) (V, bool, bool) {
+	key = c.foldKey(key)
+	if create == nil {
+		return c.Get(key)
+	}
+	if val, ok, evicted := c.Get(key); ok {
+		return val, true, evicted
+	}
+	var created V
+	c.runCreate(key, true, func() { created = create(key) })
+	val, found, evicted := c.storeCreated(key, created)
+	if found {
+		val = c.copyOut(val)
+	}
+	return val, found, evicted
+}
+
+// GetOrCreateInto is like GetOrCreate, but create fills in a zero-valued V
+// through dst instead of returning it, and the cache stores *dst. This lets a
+// creator build large values in place. The stored value is a shallow copy of
+// *dst: anything it references, such as a slice's backing array, is shared
+// with whatever the creator wired into it, so create must not hand out
+// memory it will later reuse or mutate. Because dst is passed to create, it
+// escapes to the heap; for small value types plain GetOrCreate is cheaper.
+func (c *BoundedCache[V]) GetOrCreateInto(key string, create func(dst *V)) (V, bool, bool) {
+	key = c.foldKey(key)
+	if create == nil {
+		return c.Get(key)
+	}
+	if val, ok, evicted := c.Get(key); ok {
+		return val, true, evicted
+	}
+	var dst V
+	c.runCreate(key, true, func() { create(&dst) })
+	val, found, evicted := c.storeCreated(key, dst)
+	if found {
+		val = c.copyOut(val)
+	}
+	return val, found, evicted
+}
+
+// GetOrCreateNeg is like GetOrCreate for loaders that can report that key
+// does not exist. When create returns found=false, nothing is stored, ok is
+// false, and the negative result is remembered for the NegativeTTL set with
+// WithNegativeTTL: lookups of key through GetOrCreateNeg during that window
+// miss without calling create. Any write of key clears its negative result.
+// Without WithNegativeTTL negative results are not remembered. A nil create
+// makes GetOrCreateNeg behave like Get.
+func (c *BoundedCache[V]) GetOrCreateNeg(key string, create func() (val V, found bool)) (V, bool) {
+	key = c.foldKey(key)
+	if create == nil {
+		val, ok, _ := c.Get(key)
+		return val, ok
+	}
+	if val, ok, _ := c.Get(key); ok {
+		return val, true
+	}
+	var zero V
+	if c.negative != nil && c.negativeHit(key) {
+		return zero, false
+	}
+
+	var created V
+	var found bool
+	c.runCreate(key, true, func() { created, found = create() })
+	if found {
+		val, found, _ := c.storeCreated(key, created)
+		if found {
+			val = c.copyOut(val)
+		}
+		return val, true
+	}
+
+	c.lock.Lock()
+	e, _, ok := c.lookup(key)
+	if !ok && c.negative != nil {
+		c.addNegative(key)
+	}
+	c.lock.Unlock()
+	if ok {
+		return c.copyOut(e.value), true
+	}
+	return zero, false
+}
+
+// BeginCreate is the two-phase form of GetOrCreate, for callers that want to
+// create values without handing the cache a closure. If key is cached,
+// BeginCreate returns its value, promoting it if stale, with ok=true and a
+// done that does nothing. Otherwise it returns ok=false, and the caller
+// computes the value without any lock held and calls done to store it. done
+// re-checks key first: if another goroutine stored it in the meantime, that
+// value is kept and the created one is discarded. done reports whether
+// storing the value evicted stale entries.
+//
+// No lock or reservation is held between the two phases, so a caller that
+// never calls done simply leaves key uncached and concurrent callers may
+// create the same key too. Only the first call of a done stores anything.
+func (c *BoundedCache[V]) BeginCreate(key string) (val V, ok bool, done func(created V) (evicted bool)) {
+	key = c.foldKey(key)
+	if val, ok, _ := c.Get(key); ok {
+		return val, true, func(V) bool { return false }
+	}
+	var called atomic.Bool
+	return val, false, func(created V) bool {
+		if !called.CompareAndSwap(false, true) {
+			return false
+		}
+		_, _, evicted := c.storeCreated(key, created)
+		return evicted
+	}
+}
+
+// getOrCreate implements the GetOrCreate family: look up key, and on a miss
+// call create off-lock and store its result unless it was vetoed or another
+// goroutine stored key first.
+func (c *BoundedCache[V]) getOrCreate(key string, create func() (V, bool)) (val V, found bool, evicted bool) {
+	if val, ok, evicted := c.Get(key); ok {
+		return val, true, evicted
+	}
+
+	var created V
+	var cache bool
+	c.runCreate(key, true, func() { created, cache = create() })
+	if !cache {
+		return created, false, false
+	}
+	val, found, evicted = c.storeCreated(key, created)
+	if found {
+		val = c.copyOut(val)
+	}
+	return val, found, evicted
+}
+
+// runCreate calls create, which runs a creator for key off-lock, within the
+// WithMaxConcurrentCreates limit and timed for the slow create hook. When no
+// slot is free it waits for one if wait is set and otherwise returns false
+// without calling create.
+func (c *BoundedCache[V]) runCreate(key string, wait bool, create func()) bool {
+	if c.createSlots != nil {
+		select {
+		case c.createSlots <- struct{}{}:
+		default:
+			c.createLimitHits.Add(1)
+			if !wait {
+				return false
+			}
+			c.createSlots <- struct{}{}
+		}
+		defer func() { <-c.createSlots }()
+	}
+	start := c.startCreate()
+	create()
+	c.endCreate(key, start)
+	return true
+}
+
+// startCreate returns the time an off-lock creation starts for the slow
+// create hook, or the zero time without one.
+func (c *BoundedCache[V]) startCreate() time.Time {
+	if c.onSlowCreate == nil {
+		return time.Time{}
+	}
+	return c.now()
+}
+
+// endCreate reports the creation of key that started at start to the slow
+// create hook if it took at least the threshold.
+func (c *BoundedCache[V]) endCreate(key string, start time.Time) {
+	if c.onSlowCreate == nil {
+		return
+	}
+	if took := c.now().Sub(start); took >= c.cfg.SlowCreateThreshold {
+		c.onSlowCreate(key, start, took)
+	}
+}
+
+// storeCreated stores a value created after a miss, unless another goroutine
+// stored key in the meantime, in which case that value is returned instead.
+func (c *BoundedCache[V]) storeCreated(key string, created V) (val V, found bool, evicted bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if val, ok, evicted := c.getLocked(key); ok {
+		return val, true, evicted
+	}
+	if c.onEvent != nil {
+		c.emit(EventCreate, key)
+	}
+	return created, false, c.add(key, created)
+}
+
+// RecreateAndReturnOld atomically replaces the value stored under key with
+// create(old, existed), where old is the current value and existed reports
+// whether there was one, and returns both values. The replacement is stored
+// as a new write in the fresh generation, firing OnEvict with ReasonReplace
+// for the old value. Unlike GetOrCreate, create runs with the write lock held
+// so that no other write can slip in between reading old and storing its
+// replacement; it must be quick and must not call back into the cache.
+func (c *BoundedCache[V]) RecreateAndReturnOld(key string, create func(old V, existed bool) V) (newVal V, oldVal V, existed bool, evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, _, existed := c.lookup(key)
+	newVal = create(e.value, existed)
+	if c.onEvent != nil {
+		c.emit(EventCreate, key)
+	}
+	return newVal, e.value, existed, c.add(key, newVal)
+}
+
+// GetOrDefault returns the value stored under key, promoting it if stale. On
+// a miss it stores def and returns it. found reports whether the returned
+// value was already cached rather than the default.
+func (c *BoundedCache[V]) GetOrDefault(key string, def V) (val V, found bool) {
+	key = c.foldKey(key)
+	val, set, _ := c.GetOrSet(key, def)
+	return val, !set
+}
+
+// GetOrSet returns the value stored under key, promoting it if stale, with
+// set=false. On a miss it stores val and returns it with set=true. evicted
+// reports whether the promotion or the store discarded stale entries.
+func (c *BoundedCache[V]) GetOrSet(key string, val V) (actual V, set bool, evicted bool) {
+	key = c.foldKey(key)
+	actual, set, evicted = c.getOrSet(key, val)
+	if !set {
+		actual = c.copyOut(actual)
+	}
+	return actual, set, evicted
+}
+
+func (c *BoundedCache[V]) getOrSet(key string, val V) (actual V, set bool, evicted bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	actual, hit, _, evicted := c.getPromotingLocked(key)
+	c.countLookup(hit)
+	ok := hit != lookupMiss
+	if c.onEvent != nil {
+		c.emitLookup(key, ok)
+	}
+	if ok {
+		return actual, false, evicted
+	}
+	if c.rejects(val) {
+		return val, false, evicted
+	}
+	if c.onEvent != nil {
+		c.emit(EventCreate, key)
+	}
+	return val, true, c.add(key, val)
+}
+
+// Peek returns the value stored under key without promoting it. stale
+// reports whether the entry currently lives in the stale generation.
+func (c *BoundedCache[V]) Peek(key string) (val V, ok bool, stale bool) {
+	key = c.foldKey(key)
+	c.lock.RLock()
+	e, stale, ok := c.lookup(key)
+	c.lock.RUnlock()
+	if ok {
+		return c.copyOut(e.value), true, stale
+	}
+	return e.value, false, false
+}
+
+// Entry is a value returned by GetEntry together with its metadata.
+type Entry[V any] struct {
+	Value V
+	// Version is the version of the write that stored Value, as reported by
+	// GetVersioned.
+	Version uint64
+	// Stale reports whether the entry was in the stale generation before
+	// GetEntry promoted it.
+	Stale bool
+	// NeedsRefresh reports whether the entry is older than the soft TTL set
+	// with WithSoftTTL or past the refresh time it was stored with by
+	// SetUntil. It is always false for entries subject to neither.
+	NeedsRefresh bool
+}
+
+// GetEntry is like Get but returns the value with its metadata, including
+// whether it is due for a refresh under WithSoftTTL.
+func (c *BoundedCache[V]) GetEntry(key string) (e Entry[V], ok bool, evicted bool) {
+	key = c.foldKey(key)
+	e, ok, evicted = c.getEntry(key)
+	if ok {
+		e.Value = c.copyOut(e.Value)
+	}
+	return e, ok, evicted
+}
+
+func (c *BoundedCache[V]) getEntry(key string) (Entry[V], bool, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, stale, _ := c.lookup(key)
+	val, hit, _, evicted := c.getPromotingLocked(key)
+	c.countLookup(hit)
+	ok := hit != lookupMiss
+	if c.onEvent != nil {
+		c.emitLookup(key, ok)
+	}
+	if !ok {
+		return Entry[V]{}, false, evicted
+	}
+	stored, _, _ := c.rawLookup(key)
+	return Entry[V]{
+		Value:        val,
+		Version:      stored.version,
+		Stale:        stale,
+		NeedsRefresh: c.needsRefresh(stored),
+	}, true, evicted
+}
+
+// PeekResult is a value found by PeekMany and whether it was stale.
+type PeekResult[V any] struct {
+	Value V
+	Stale bool
+}
+
+// PeekMany is the batch form of Peek. It looks up every key under a single
+// read lock and returns results only for keys that are present.
+func (c *BoundedCache[V]) PeekMany(keys []string) map[string]PeekResult[V] {
+	c.lock.RLock()
+	results := make(map[string]PeekResult[V], len(keys))
+	for _, key := range keys {
+		if e, stale, ok := c.lookup(c.foldKey(key)); ok {
+			results[key] = PeekResult[V]{Value: e.value, Stale: stale}
+		}
+	}
+	c.lock.RUnlock()
+	if c.copyOnRead != nil {
+		for key, res := range results {
+			res.Value = c.copyOnRead(res.Value)
+			results[key] = res
+		}
+	}
+	return results
+}
+
+// GetGroup returns every entry whose key starts with prefix, read under a
+// single lock without promoting anything. Keys are not indexed by prefix, so
+// GetGroup scans the whole cache and costs O(n) in the number of entries.
+func (c *BoundedCache[V]) GetGroup(prefix string) map[string]V {
+	prefix = c.foldKey(prefix)
+	c.lock.RLock()
+	group := make(map[string]V)
+	c.rangeLocked(func(key string, e entry[V]) bool {
+		if strings.HasPrefix(key, prefix) {
+			group[key] = e.value
+		}
+		return true
+	})
+	c.lock.RUnlock()
+	if c.copyOnRead != nil {
+		for key, val := range group {
+			group[key] = c.copyOnRead(val)
+		}
+	}
+	return group
+}
+
+// ExportOlderThan returns the live entries stored at least age ago, without
+// promoting or removing them, so that cold data can be archived before it is
+// evicted; RemoveMany can drop the exported keys afterwards. It scans the
+// whole cache under the read lock, in O(n). Write times are only recorded
+// when the cache was built with WithIdleTimeout, WithSoftTTL or
+// WithMaxLifetime; without any of them, ExportOlderThan returns nil.
+func (c *BoundedCache[V]) ExportOlderThan(age time.Duration) map[string]V {
+	if !c.expiring() {
+		return nil
+	}
+	c.lock.RLock()
+	cutoff := c.now().Add(-age).UnixNano()
+	old := make(map[string]V)
+	c.rangeLocked(func(key string, e entry[V]) bool {
+		if e.written <= cutoff {
+			old[key] = e.value
+		}
+		return true
+	})
+	c.lock.RUnlock()
+	if c.copyOnRead != nil {
+		for key, val := range old {
+			old[key] = c.copyOnRead(val)
+		}
+	}
+	return old
+}
+
+// EntryStatus describes where an entry stands in the generational lifecycle.
+type EntryStatus int
+
+const (
+	// StatusAbsent means the key is not cached.
+	StatusAbsent EntryStatus = iota
+	// StatusFresh means the entry is in the fresh generation.
+	StatusFresh
+	// StatusStale means the entry is in the stale generation and will be
+	// discarded by the shift after next unless it is accessed.
+	StatusStale
+	// StatusAboutToDrop means the entry is stale and the fresh generation is
+	// full, so the next insert of a new key discards it. Under
+	// WithSingleGeneration it means the one generation is full.
+	StatusAboutToDrop
+)
+
+func (s EntryStatus) String() string {
+	switch s {
+	case StatusAbsent:
+		return "absent"
+	case StatusFresh:
+		return "fresh"
+	case StatusStale:
+		return "stale"
+	case StatusAboutToDrop:
+		return "about-to-drop"
+	}
+	return "unknown"
+}
+
+// PeekStatus is like Peek but reports how close the entry is to eviction.
+// Under WithSingleGeneration every entry is fresh until the generation is
+// full, and then about to drop.
+func (c *BoundedCache[V]) PeekStatus(key string) (V, EntryStatus) {
+	key = c.foldKey(key)
+	c.lock.RLock()
+	e, stale, ok := c.lookup(key)
+	status := StatusStale
+	switch {
+	case !ok:
+		status = StatusAbsent
+	case c.cfg.SingleGeneration && c.freshItems.len() >= c.shiftAt():
+		status = StatusAboutToDrop
+	case !stale:
+		status = StatusFresh
+	case c.freshItems.len() >= c.shiftAt():
+		status = StatusAboutToDrop
+	}
+	c.lock.RUnlock()
+	if ok {
+		e.value = c.copyOut(e.value)
+	}
+	return e.value, status
+}
+
+// GetVersioned returns the value stored under key together with its version,
+// without promoting it. Every write assigns a new version that is unique for
+// the lifetime of the cache, so a version observed here identifies exactly
+// one write of key.
+func (c *BoundedCache[V]) GetVersioned(key string) (val V, version uint64, ok bool) {
+	key = c.foldKey(key)
+	c.lock.RLock()
+	e, _, ok := c.lookup(key)
+	c.lock.RUnlock()
+	if ok {
+		e.value = c.copyOut(e.value)
+	}
+	return e.value, e.version, ok
+}
+
+// CompareAndSwap replaces the value stored under key with newVal, in its
+// current generation, if its version still equals expectedVersion. The write
+// assigns a new version. CompareAndSwap fails if key was overwritten, or
+// removed or evicted, since expectedVersion was read: a key that is re-added
+// after eviction never reuses an earlier version.
+func (c *BoundedCache[V]) CompareAndSwap(key string, expectedVersion uint64, newVal V) (ok bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, stale, ok := c.lookup(key)
+	if !ok || e.version != expectedVersion || c.rejects(newVal) {
+		return false
+	}
+	if c.onEvict != nil {
+		c.onEvict(key, e.value, ReasonReplace)
+	}
+	created := e.created
+	e = c.newEntry(newVal)
+	if c.cfg.MaxLifetime > 0 {
+		e.created = created
+	}
+	if stale {
+		c.staleItems.set(key, e)
+	} else {
+		c.freshItems.set(key, e)
+	}
+	return true
+}
+
+// Remove deletes key from the cache and reports whether it was present.
+func (c *BoundedCache[V]) Remove(key string) bool {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.remove(key)
+	if ok && c.onEvict != nil {
+		c.onEvict(key, e.value, ReasonRemove)
+	}
+	return ok
+}
+
+// RemoveMany deletes every listed key under a single write lock, so no
+// reader observes some of them gone and others still cached, and returns how
+// many were present. OnEvict fires with ReasonRemove for each removed entry.
+func (c *BoundedCache[V]) RemoveMany(keys []string) (removed int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, key := range keys {
+		key = c.foldKey(key)
+		e, ok := c.remove(key)
+		if !ok {
+			continue
+		}
+		removed++
+		if c.onEvict != nil {
+			c.onEvict(key, e.value, ReasonRemove)
+		}
+	}
+	return removed
+}
+
+// Keys returns the keys of all entries. The order is unspecified unless the
+// cache was built with WithOrderedIteration, in which case keys are returned
+// in insertion order.
+func (c *BoundedCache[V]) Keys() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	keys := make([]string, 0, c.freshItems.len()+c.staleItems.len())
+	c.rangeLocked(func(key string, _ entry[V]) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// EvictionCandidates returns the keys in the stale generation: the entries
+// the next shift will discard unless they are accessed first. Under
+// WithSingleGeneration, where a shift discards the one generation, they are
+// its keys once it is full, and none before. The order is unspecified.
+func (c *BoundedCache[V]) EvictionCandidates() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	doomed := c.staleItems
+	if c.cfg.SingleGeneration {
+		if c.freshItems.len() < c.shiftAt() {
+			return []string{}
+		}
+		doomed = c.freshItems
+	}
+	keys := make([]string, 0, doomed.len())
+	for key, e := range doomed.all() {
+		if !c.expired(e) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// KeysFunc returns an iterator over the keys for which pred returns true, in
+// the same order as Range. Keys are produced lazily: the read lock is taken
+// when iteration starts and held until it finishes or the loop breaks, so the
+// cache must not be modified from the loop body or pred.
+func (c *BoundedCache[V]) KeysFunc(pred func(key string) bool) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		c.rangeLocked(func(key string, _ entry[V]) bool {
+			return !pred(key) || yield(key)
+		})
+	}
+}
+
+// Range calls fn for each entry until fn returns false, without promoting
+// anything. The order is unspecified unless the cache was built with
+// WithOrderedIteration, in which case entries are visited in insertion order.
+// Range holds the read lock throughout, so fn must not modify the cache.
+func (c *BoundedCache[V]) Range(fn func(key string, value V) bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	c.rangeLocked(func(key string, e entry[V]) bool {
+		return fn(key, e.value)
+	})
+}
+
+// WithReadLock holds the read lock while fn scans the fresh and stale
+// generations in place through the two iterators, without the copy Keys or
+// GetGroup make. Expired entries are skipped and nothing is promoted.
+//
+// This is an expert escape hatch: fn runs with the lock held, so it must be
+// quick and must not call back into the cache, which would deadlock as soon
+// as a writer is queued, and the iterators must not be used after fn
+// returns. Values are handed out as stored, not through WithCopyOnRead, and
+// must not be mutated.
+func (c *BoundedCache[V]) WithReadLock(fn func(fresh, stale iter.Seq2[string, V])) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	fn(c.liveValues(c.freshItems), c.liveValues(c.staleItems))
+}
+
+// liveValues returns an iterator over the unexpired values of s. The caller
+// must hold the lock while it is used.
+func (c *BoundedCache[V]) liveValues(s store[V]) iter.Seq2[string, V] {
+	return func(yield func(string, V) bool) {
+		for key, e := range s.all() {
+			if !c.expired(e) && !yield(key, e.value) {
+				return
+			}
+		}
+	}
+}
+
+// Purge removes every entry and remembered negative result, and advances the
+// cache's Generation.
+func (c *BoundedCache[V]) Purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reset(ReasonPurge)
+}
+
+// ReplaceAll atomically replaces the cache's contents with items and advances
+// its Generation. Items beyond what the cache can hold are evicted as if they
+// had been added one by one, in unspecified order.
+func (c *BoundedCache[V]) ReplaceAll(items map[string]V) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reset(ReasonReplace)
+	for key, val := range items {
+		c.add(c.foldKey(key), val)
+	}
+}
+
+// PromoteMany moves the live stale entries among keys into the fresh
+// generation under a single write lock, so that a group about to turn hot
+// survives the next shift together, and reports how many moved. Keys that
+// are fresh or absent are skipped. When the group does not fit in the room
+// left in the fresh generation, the cache shifts once first, discarding the
+// rest of the stale generation, and evicted reports it; at most a full fresh
+// generation is promoted, the earliest keys first, and the others stay
+// stale, so the cache never grows past MaxItems. Under
+// WithEvictionRateLimit the shift may be deferred like any other, and then
+// only the room left is filled. OnPromote and WithCopyOnPromote apply to
+// each moved entry as they do for Get.
+func (c *BoundedCache[V]) PromoteMany(keys []string) (promoted int, evicted bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var group []entryAt[V]
+	for _, key := range keys {
+		key = c.foldKey(key)
+		e, ok := c.staleItems.get(key)
+		if !ok || c.expired(e) {
+			continue
+		}
+		if len(group) == c.shiftAt() {
+			break
+		}
+		c.staleItems.delete(key)
+		group = append(group, entryAt[V]{key: key, e: e})
+	}
+	if len(group) == 0 {
+		return 0, false
+	}
+	if c.freshItems.len()+len(group) > c.shiftAt() && c.shiftAllowed() {
+		evicted = c.shift()
+	}
+	for _, p := range group {
+		if c.freshItems.len() >= c.shiftAt() {
+			// A shift carried entries over; leave the rest stale.
+			c.staleItems.set(p.key, p.e)
+			continue
+		}
+		if c.copyOnPromote != nil {
+			p.e.value = c.copyOnPromote(p.e.value)
+		}
+		if c.onPromote != nil {
+			c.onPromote(p.key, p.e.value)
+		}
+		c.freshItems.set(p.key, p.e)
+		promoted++
+	}
+	if evicted && c.cfg.OrderedIteration {
+		c.pruneOrder()
+	}
+	return promoted, evicted
+}
+
+// Merge adds every live entry of other to c. A key live in both caches is
+// stored with onConflict(mine, theirs), or with other's value when
+// onConflict is nil. Every merged entry is a new write in c's fresh
+// generation, other's stale entries before its fresh ones, so the usual
+// shifts make room: when c and other together hold more than c's MaxItems,
+// c's own entries are the first to go, and of other's the stale ones before
+// the fresh. other is left unchanged.
+//
+// other's entries are copied out under its read lock and then added under
+// c's write lock; the caches are never locked together, so concurrent merges
+// in opposite directions cannot deadlock, and entries other gains meanwhile
+// are not merged. onConflict runs with c's lock held and must not call back
+// into either cache.
+func (c *BoundedCache[V]) Merge(other *BoundedCache[V], onConflict func(mine, theirs V) V) {
+	other.lock.RLock()
+	merged := make([]entryAt[V], 0, other.freshItems.len()+other.staleItems.len())
+	for _, items := range []store[V]{other.staleItems, other.freshItems} {
+		for key, e := range items.all() {
+			if !other.expired(e) {
+				merged = append(merged, entryAt[V]{key: key, e: e})
+			}
+		}
+	}
+	other.lock.RUnlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, m := range merged {
+		key, val := c.foldKey(m.key), m.e.value
+		if onConflict != nil {
+			if mine, _, ok := c.lookup(key); ok {
+				val = onConflict(mine.value, val)
+			}
+		}
+		c.add(key, val)
+	}
+}
+
+// Generation returns a counter that advances every time the cache is reset
+// by Purge or ReplaceAll. A holder of a value read from the cache can compare
+// generations to detect that the cache was reset after the read.
+func (c *BoundedCache[V]) Generation() uint64 {
+	return c.generation.Load()
+}
+
+// Drain calls fn for every entry, fresh generation first, and leaves the cache
+// empty. It holds the write lock throughout, so fn must not call back into
+// the cache. Drained entries are handed to fn rather than evicted, so the
+// OnEvict hook does not fire for them.
+func (c *BoundedCache[V]) Drain(fn func(key string, value V)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, e := range c.freshItems.all() {
+		fn(key, e.value)
+	}
+	for key, e := range c.staleItems.all() {
+		fn(key, e.value)
+	}
+	c.freshItems.clear()
+	c.staleItems = c.makeStore(0)
+	c.order = c.order[:0]
+}
+
+// FlushStale removes the whole stale generation and returns its live
+// entries, leaving the fresh generation untouched, for write-behind caches
+// that flush entries once they have aged out of the fresh half. Like Drain,
+// it hands the entries to the caller rather than evicting them, so OnEvict
+// does not fire for them; stale entries that had already expired are
+// dropped and reported as expired instead of being returned.
+func (c *BoundedCache[V]) FlushStale() map[string]V {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	flushed := make(map[string]V, c.staleItems.len())
+	for key, e := range c.staleItems.all() {
+		if c.expired(e) {
+			c.expire(key, e.value)
+			continue
+		}
+		flushed[key] = e.value
+	}
+	c.staleItems = c.makeStore(0)
+	if c.cfg.OrderedIteration {
+		c.pruneOrder()
+	}
+	return flushed
+}
+
+// Compact merges the stale generation into the fresh one when every entry
+// fits under the fresh cap, so that an under-filled cache does not discard
+// still-warm stale entries on its next shift. It reports whether the merge
+// happened.
+func (c *BoundedCache[V]) Compact() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.staleItems.len() == 0 || c.freshItems.len()+c.staleItems.len() >= c.maxItemMapLen {
+		return false
+	}
+	for key, e := range c.staleItems.all() {
+		c.freshItems.set(key, e)
+	}
+	c.staleItems = c.makeStore(0)
+	return true
+}
+
+// ShrinkToFit rebuilds both generations at their current size, so that maps
+// grown for a larger working set than the cache now holds, for example
+// before a Resize down or a wave of removals, release their oversized
+// storage to the garbage collector; Go maps never shrink on their own. It
+// copies every entry under the write lock, in O(n) time, so it is meant for
+// occasional maintenance rather than hot paths. The fresh generation grows
+// again as entries are added, as it does after a shift.
+func (c *BoundedCache[V]) ShrinkToFit() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.freshItems = c.rebuildStore(c.freshItems)
+	c.staleItems = c.rebuildStore(c.staleItems)
+}
+
+// rebuildStore returns a copy of s sized for its current length. The caller
+// must hold the write lock.
+func (c *BoundedCache[V]) rebuildStore(s store[V]) store[V] {
+	fit := c.makeStore(s.len())
+	for key, e := range s.all() {
+		fit.set(key, e)
+	}
+	return fit
+}
+
+// getLocked looks key up in both generations, promoting a stale hit. The
+// caller must hold the write lock.
+func (c *BoundedCache[V]) getLocked(key string) (val V, ok bool, evicted bool) {
+	val, hit, _, evicted := c.getPromotingLocked(key)
+	return val, hit != lookupMiss, evicted
+}
+
+// lookupHit is where getPromotingLocked found a value, for the callers that
+// count their lookups in Stats.
+type lookupHit int
+
+const (
+	lookupMiss  lookupHit = iota
+	lookupFresh           // the fresh generation
+	lookupStale           // the stale generation
+	lookupSpare           // the victim cache or the fallback; not counted
+)
+
+// getPromotingLocked is getLocked that reports where the value was found
+// and whether a stale hit was promoted. It counts nothing in Stats, which
+// is left to the public lookup methods through countLookup, so that internal
+// lookups do not skew the counters. The caller must hold the write lock.
+func (c *BoundedCache[V]) getPromotingLocked(key string) (val V, hit lookupHit, promoted bool, evicted bool) {
+	e, stale, ok := c.lookup(key)
+	if !ok {
+		if c.expiring() {
+			// Drop the entry if it is merely expired.
+			if old, ok := c.remove(key); ok {
+				c.expire(key, old.value)
+			}
+		}
+		if c.victim != nil {
+			if val, ok, _ := c.victim.Peek(key); ok {
+				c.victim.Remove(key)
+				return val, lookupSpare, false, c.add(key, val)
+			}
+		}
+		if c.fallback != nil {
+			if val, ok, _ := c.fallback.Peek(key); ok {
+				return val, lookupSpare, false, c.add(key, val)
+			}
+		}
+		var zero V
+		return zero, lookupMiss, false, false
+	}
+	if c.healthCheck != nil && !c.healthCheck(e.value) {
+		c.remove(key)
+		if c.onEvict != nil {
+			c.onEvict(key, e.value, ReasonUnhealthy)
+		}
+		var zero V
+		return zero, lookupMiss, false, false
+	}
+	if c.writeOnHit {
+		if c.cfg.IdleTimeout > 0 {
+			e.accessed = c.now().UnixNano()
+		}
+		if c.cfg.AccessTracking {
+			c.accessClock++
+			e.tick = c.accessClock
+		}
+		if c.cfg.HitCounting {
+			e.hits++
+		}
+		if !stale {
+			c.freshItems.set(key, e)
+		}
+	}
+	if stale {
+		if c.cfg.DeferredPromotion && !e.deferred && c.freshItems.len() >= c.shiftAt() {
+			// Promoting would shift right away; give the entry a second
+			// chance to prove it is worth it instead.
+			e.deferred = true
+			c.staleItems.set(key, e)
+			return e.value, lookupStale, false, false
+		}
+		if c.copyOnPromote != nil {
+			e.value = c.copyOnPromote(e.value)
+		}
+		if c.onPromote != nil {
+			c.onPromote(key, e.value)
+		}
+		return e.value, lookupStale, true, c.insert(key, e)
+	}
+	return e.value, lookupFresh, false, false
+}
+
+// countLookup counts a lookup made by a public lookup method in Stats.
+func (c *BoundedCache[V]) countLookup(hit lookupHit) {
+	switch {
+	case hit == lookupMiss, hit == lookupStale && c.cfg.StaleHitsAsMisses:
+		c.misses.Add(1)
+	case hit == lookupStale:
+		c.staleHits.Add(1)
+	case hit == lookupFresh:
+		c.freshHits.Add(1)
+	}
+}
+
+// foldKey returns key in the form it is stored in: lowercased under
+// WithCaseInsensitiveKeys and unchanged otherwise.
+func (c *BoundedCache[V]) foldKey(key string) string {
+	if !c.cfg.CaseInsensitiveKeys {
+		return key
+	}
+	return strings.ToLower(key)
+}
+
+// copyOut returns the copy of val made by the WithCopyOnRead function, or
+// val itself without one. It runs user code, so the caller must not hold the
+// lock.
+func (c *BoundedCache[V]) copyOut(val V) V {
+	if c.copyOnRead == nil {
+		return val
+	}
+	return c.copyOnRead(val)
+}
+
+// lookup returns the live entry stored under key and whether it is stale,
+// without promoting it. Entries that have been idle for longer than
+// IdleTimeout, written longer than HardTTL ago, or created longer than
+// MaxLifetime ago, are reported as absent. The caller must hold the lock.
+func (c *BoundedCache[V]) lookup(key string) (e entry[V], stale bool, ok bool) {
+	e, stale, ok = c.rawLookup(key)
+	if ok && c.expired(e) {
+		var zero entry[V]
+		return zero, false, false
+	}
+	return e, stale, ok
+}
+
+// rawLookup is like lookup but also returns expired entries. The caller must
+// hold the lock.
+func (c *BoundedCache[V]) rawLookup(key string) (e entry[V], stale bool, ok bool) {
+	if e, ok = c.freshItems.get(key); ok {
+		return e, false, true
+	}
+	e, ok = c.staleItems.get(key)
+	return e, ok, ok
+}
+
+// shiftAt returns the fresh generation size at which an insert shifts: the
+// per-generation cap, or the WithMinFreshBeforeShift floor if that is
+// larger.
+func (c *BoundedCache[V]) shiftAt() int {
+	return max(c.maxItemMapLen, c.cfg.MinFreshBeforeShift)
+}
+
+// expiring reports whether entries can expire: whether IdleTimeout,
+// HardTTL or MaxLifetime is set.
+func (c *BoundedCache[V]) expiring() bool {
+	return c.cfg.IdleTimeout > 0 || c.cfg.HardTTL > 0 || c.cfg.MaxLifetime > 0
+}
+
+// expired reports whether e has been idle for longer than IdleTimeout, was
+// written longer than HardTTL ago, or was created longer than MaxLifetime
+// ago.
+func (c *BoundedCache[V]) expired(e entry[V]) bool {
+	if !c.expiring() {
+		return false
+	}
+	now := c.now().UnixNano()
+	return c.cfg.IdleTimeout > 0 && now-e.accessed >= int64(c.cfg.IdleTimeout) ||
+		c.cfg.HardTTL > 0 && now-e.written >= int64(c.cfg.HardTTL) ||
+		c.cfg.MaxLifetime > 0 && now-e.created >= int64(c.cfg.MaxLifetime)
+}
+
+// expire reports the expiry of key to the OnExpire and OnEvict callbacks
+// after it has been dropped. The caller must hold the write lock.
+func (c *BoundedCache[V]) expire(key string, value V) {
+	if c.onExpire != nil {
+		c.onExpire(key, value)
+	}
+	if c.onEvict != nil {
+		c.onEvict(key, value, ReasonExpired)
+	}
+}
+
+// needsRefresh reports whether e was written at least SoftTTL ago or has
+// reached the refresh time set by SetUntil.
+func (c *BoundedCache[V]) needsRefresh(e entry[V]) bool {
+	if c.cfg.SoftTTL == 0 && e.refreshAt == 0 {
+		return false
+	}
+	now := c.now().UnixNano()
+	return c.cfg.SoftTTL > 0 && now-e.written >= int64(c.cfg.SoftTTL) ||
+		e.refreshAt != 0 && now >= e.refreshAt
+}
+
+// newEntry wraps val in an entry carrying the next version. The caller must
+// hold the write lock.
+func (c *BoundedCache[V]) newEntry(val V) entry[V] {
+	c.version++
+	e := entry[V]{value: val, version: c.version}
+	if c.expiring() {
+		now := c.now().UnixNano()
+		e.accessed, e.written, e.created = now, now, now
+	}
+	if c.cfg.AccessTracking {
+		c.accessClock++
+		e.tick = c.accessClock
+	}
+	return e
+}
+
+// inherit gives e, which is about to replace the entry under key, the
+// creation time of that entry if it is live, so that overwriting a key does
+// not extend its MaxLifetime. The caller must hold the write lock.
+func (c *BoundedCache[V]) inherit(key string, e *entry[V]) {
+	if c.cfg.MaxLifetime == 0 {
+		return
+	}
+	if old, _, ok := c.lookup(key); ok {
+		e.created = old.created
+	}
+}
+
+// add stores val under key as a new write. The caller must hold the write
+// lock.
+func (c *BoundedCache[V]) add(key string, val V) (evicted bool) {
+	return c.addWithPriority(key, val, 0)
+}
+
+// addWithPriority is add for an entry with the given priority. The caller
+// must hold the write lock.
+func (c *BoundedCache[V]) addWithPriority(key string, val V, priority int) (evicted bool) {
+	if c.rejects(val) {
+		return false
+	}
+	if c.changeDetector != nil {
+		if old, stale, ok := c.lookup(key); ok && !c.changeDetector(old.value, val) {
+			c.overwrite(key, old, stale, val, priority)
+			return false
+		}
+	}
+	if c.onEvict != nil {
+		if old, _, ok := c.rawLookup(key); ok {
+			c.onEvict(key, old.value, ReasonReplace)
+		}
+	}
+	e := c.newEntry(val)
+	c.inherit(key, &e)
+	if priority > 0 {
+		e.priority = priority
+		c.prioritized = true
+	}
+	return c.insert(key, e)
+}
+
+// overwrite replaces the value of the existing entry e under key in place,
+// for a write the change detector judged a no-op: the entry keeps its
+// generation and version and OnEvict is not told. Like any write it drops
+// the entry's metadata and refresh time, which SetWithMeta and SetUntil
+// reattach. The caller must hold the write lock.
+func (c *BoundedCache[V]) overwrite(key string, e entry[V], stale bool, val V, priority int) {
+	e.value = val
+	e.priority = max(priority, 0)
+	e.meta, e.refreshAt = nil, 0
+	if priority > 0 {
+		c.prioritized = true
+	}
+	if c.expiring() {
+		now := c.now().UnixNano()
+		e.accessed, e.written = now, now
+	}
+	if stale {
+		c.staleItems.set(key, e)
+	} else {
+		c.freshItems.set(key, e)
+	}
+}
+
+// rejects reports whether val must not be stored because RejectNil is set
+// and val is nil.
+func (c *BoundedCache[V]) rejects(val V) bool {
+	return c.cfg.RejectNil && isNil(val)
+}
+
+// isNil reports whether v is a nil interface or a nil pointer, map, channel
+// or function. Nil slices are valid empty values and are not considered nil.
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.UnsafePointer, reflect.Map, reflect.Chan, reflect.Func, reflect.Interface:
+		return rv.IsNil()
+	}
+	return false
+}
+
+// insert stores e under key in the fresh generation, shifting generations
+// first if the fresh map is full. The caller must hold the write lock.
+func (c *BoundedCache[V]) insert(key string, e entry[V]) (evicted bool) {
+	if _, ok := c.freshItems.get(key); ok {
+		c.freshItems.set(key, e)
+		return false
+	}
+	if c.negative != nil {
+		delete(c.negative, key)
+	}
+	_, promoted := c.staleItems.get(key)
+	if promoted {
+		c.staleItems.delete(key)
+	} else if c.ghosts != nil && c.ghosts.take(key) {
+		c.churn.Add(1)
+	}
+	if c.underPressure != nil && c.underPressure() {
+		// Yield memory now: drop the stale generation without waiting for
+		// the fresh one to fill.
+		evicted = c.shift()
+	}
+	if c.freshItems.len() >= c.shiftAt() && c.shiftAllowed() {
+		evicted = c.shift() || evicted
+	}
+	c.freshItems.set(key, e)
+	if len(c.waiters) > 0 {
+		c.notifyWaiters(key)
+	}
+	if c.cfg.OrderedIteration {
+		if evicted {
+			c.pruneOrder()
+		}
+		if !promoted {
+			c.order = append(c.order, key)
+		}
+	}
+	if c.onHighWater != nil {
+		c.checkWatermark()
+	}
+	return evicted
+}
+
+// negativeHit reports whether key has an unexpired negative result, dropping
+// it if it has expired.
+func (c *BoundedCache[V]) negativeHit(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	expires, ok := c.negative[key]
+	if ok && !c.now().Before(expires) {
+		delete(c.negative, key)
+		return false
+	}
+	return ok
+}
+
+// addNegative remembers a negative result for key. The negative set is
+// bounded by the per-generation cap: when it is full, expired results are
+// swept, and if none were expired the whole set is discarded. The caller must
+// hold the write lock.
+func (c *BoundedCache[V]) addNegative(key string) {
+	now := c.now()
+	if len(c.negative) >= c.maxItemMapLen {
+		for k, expires := range c.negative {
+			if !now.Before(expires) {
+				delete(c.negative, k)
+			}
+		}
+		if len(c.negative) >= c.maxItemMapLen {
+			clear(c.negative)
+		}
+	}
+	c.negative[key] = now.Add(c.cfg.NegativeTTL)
+}
+
+// rebalance implements Rebalance. The caller must hold the write lock.
+func (c *BoundedCache[V]) rebalance() {
+	excess := c.freshItems.len() + c.staleItems.len() - c.maxItems()
+	for _, items := range []store[V]{c.staleItems, c.freshItems} {
+		for key, e := range items.all() {
+			if excess <= 0 {
+				break
+			}
+			items.delete(key)
+			excess--
+			c.discard(key, e.value)
+		}
+	}
+	move := func(from, to store[V], n int) {
+		for key, e := range from.all() {
+			if n <= 0 {
+				break
+			}
+			from.delete(key)
+			to.set(key, e)
+			n--
+		}
+	}
+	move(c.freshItems, c.staleItems, c.freshItems.len()-c.maxItemMapLen)
+	move(c.staleItems, c.freshItems, min(c.staleItems.len()-c.maxItemMapLen, c.maxItemMapLen-c.freshItems.len()))
+	if c.cfg.OrderedIteration {
+		c.pruneOrder()
+	}
+}
+
+// reset empties the cache, reporting each entry to OnEvict with reason, and
+// advances its generation. The caller must hold the write lock.
+func (c *BoundedCache[V]) reset(reason EvictReason) {
+	if c.onEvict != nil && reason == ReasonPurge && c.teardownOrder != nil {
+		n := c.freshItems.len() + c.staleItems.len()
+		keys, values := make([]string, 0, n), make([]V, 0, n)
+		for _, items := range []store[V]{c.freshItems, c.staleItems} {
+			for key, e := range items.all() {
+				keys = append(keys, key)
+				values = append(values, e.value)
+			}
+		}
+		for _, key := range c.teardownOrder(keys, values) {
+			e, _, _ := c.rawLookup(key)
+			c.onEvict(key, e.value, reason)
+		}
+	} else if c.onEvict != nil {
+		for _, items := range []store[V]{c.freshItems, c.staleItems} {
+			for key, e := range items.all() {
+				c.onEvict(key, e.value, reason)
+			}
+		}
+	}
+	c.freshItems = c.makeStore(c.cfg.InitialCapacity)
+	c.staleItems = c.makeStore(0)
+	c.order = c.order[:0]
+	if c.negative != nil {
+		clear(c.negative)
+	}
+	c.generation.Add(1)
+}
+
+// teardownKeys reorders keys to match order(values), where values[i] is the
+// value stored under keys[i]. Keys sharing a value keep their relative order,
+// and keys whose value order dropped go last.
+func teardownKeys[V comparable](keys []string, values []V, order func([]V) []V) []string {
+	byValue := make(map[V][]int, len(values))
+	for i, v := range values {
+		byValue[v] = append(byValue[v], i)
+	}
+	ordered := make([]string, 0, len(keys))
+	done := make([]bool, len(keys))
+	for _, v := range order(slices.Clone(values)) {
+		if idx := byValue[v]; len(idx) > 0 {
+			ordered = append(ordered, keys[idx[0]])
+			done[idx[0]] = true
+			byValue[v] = idx[1:]
+		}
+	}
+	for i, key := range keys {
+		if !done[i] {
+			ordered = append(ordered, key)
+		}
+	}
+	return ordered
+}
+
+// remove deletes key from whichever generation holds it. The caller must hold
+// the write lock.
+func (c *BoundedCache[V]) remove(key string) (e entry[V], ok bool) {
+	if e, ok = c.freshItems.get(key); ok {
+		c.freshItems.delete(key)
+	} else if e, ok = c.staleItems.get(key); ok {
+		c.staleItems.delete(key)
+	} else {
+		return e, false
+	}
+	if c.cfg.OrderedIteration {
+		c.order = slices.DeleteFunc(c.order, func(k string) bool { return k == key })
+	}
+	return e, true
+}
+
+// pruneOrder drops keys that are no longer stored from the insertion order.
+// The caller must hold the write lock.
+func (c *BoundedCache[V]) pruneOrder() {
+	c.order = slices.DeleteFunc(c.order, func(key string) bool {
+		_, _, ok := c.rawLookup(key)
+		return !ok
+	})
+}
+
+// rangeLocked calls fn for each live entry until it returns false, in
+// insertion order when OrderedIteration is enabled and fresh generation first
+// otherwise. The caller must hold the lock.
+func (c *BoundedCache[V]) rangeLocked(fn func(key string, e entry[V]) bool) {
+	if c.cfg.OrderedIteration {
+		for _, key := range c.order {
+			if e, _, ok := c.lookup(key); ok && !fn(key, e) {
+				return
+			}
+		}
+		return
+	}
+	for key, e := range c.freshItems.all() {
+		if !c.expired(e) && !fn(key, e) {
+			return
+		}
+	}
+	for key, e := range c.staleItems.all() {
+		if !c.expired(e) && !fn(key, e) {
+			return
+		}
+	}
+}
+
+// checkWatermark fires the high watermark hook on an upward crossing and
+// re-arms it once the fill ratio falls back to the low watermark. The caller
+// must hold the write lock.
+func (c *BoundedCache[V]) checkWatermark() {
+	ratio := c.fillRatio()
+	switch {
+	case !c.aboveWatermark && ratio > c.cfg.HighWatermark:
+		c.aboveWatermark = true
+		go c.onHighWater()
+	case c.aboveWatermark && ratio <= c.cfg.LowWatermark:
+		c.aboveWatermark = false
+	}
+}
+
+// fillRatio returns Len divided by MaxItems. The caller must hold the lock.
+func (c *BoundedCache[V]) fillRatio() float64 {
+	return float64(c.freshItems.len()+c.staleItems.len()) / float64(c.maxItems())
+}
+
+// maxItems returns the maximum number of entries. The caller must hold the
+// lock once the cache is shared.
+func (c *BoundedCache[V]) maxItems() int {
+	if c.cfg.SingleGeneration {
+		return c.maxItemMapLen
+	}
+	return c.maxItemMapLen * 2
+}
+
+// generationCap returns the per-generation cap for a cache of maxItems
+// entries: half of them, or all of them under WithSingleGeneration.
+func (c *BoundedCache[V]) generationCap(maxItems int) int {
+	if c.cfg.SingleGeneration {
+		return max(maxItems, 1)
+	}
+	return max(maxItems/2, 1)
+}
+
+// shift demotes the fresh generation to stale and discards the old stale
+// generation, except for entries rescued by the eviction filter or carried
+// over by priority, which move into the new fresh generation. It reports
+// whether any entries were discarded. The caller must hold the write lock.
+func (c *BoundedCache[V]) shift() (evicted bool) {
+	if c.onEvent != nil {
+		c.emit(EventShift, "")
+	}
+	if c.cfg.SingleGeneration {
+		// There is no second chance: the full generation is discarded
+		// itself, and the empty stale generation stays empty.
+		c.freshItems, c.staleItems = c.staleItems, c.freshItems
+	}
+	fresh := c.makeStore(c.maxItemMapLen)
+	if c.evictionFilter == nil && c.onEvent == nil && c.onEvict == nil && c.victim == nil && !c.prioritized && c.ghosts == nil {
+		evicted = c.staleItems.len() > 0
+	} else {
+		drop := func(key string, e entry[V]) {
+			evicted = true
+			c.discard(key, e.value)
+		}
+		var candidates []entryAt[V]
+		for key, e := range c.staleItems.all() {
+			// Leave room for the insert that triggered the shift.
+			if c.evictionFilter != nil && fresh.len() < c.maxItemMapLen-1 && c.evictionFilter(key, e.value) {
+				fresh.set(key, e)
+				continue
+			}
+			if e.priority > 0 {
+				candidates = append(candidates, entryAt[V]{key, e})
+				continue
+			}
+			drop(key, e)
+		}
+		if len(candidates) > 0 {
+			slices.SortFunc(candidates, func(a, b entryAt[V]) int {
+				return cmp.Compare(b.e.priority, a.e.priority)
+			})
+			budget := min(max(c.maxItemMapLen/4, 1), c.maxItemMapLen-1-fresh.len())
+			for i, cand := range candidates {
+				if i < budget {
+					fresh.set(cand.key, cand.e)
+				} else {
+					drop(cand.key, cand.e)
+				}
+			}
+		}
+	}
+	c.staleItems = c.freshItems
+	c.freshItems = fresh
+	return evicted
+}
+
+// discard reports an entry dropped for lack of room to the event hook, the
+// churn tracker, and the victim cache or else OnEvict. The caller must hold
+// the write lock and have deleted the entry already.
+func (c *BoundedCache[V]) discard(key string, value V) {
+	if c.onEvent != nil {
+		c.emit(EventEvict, key)
+	}
+	if c.ghosts != nil {
+		c.ghosts.add(key, c.maxItemMapLen)
+	}
+	if c.victim != nil {
+		c.victim.Add(key, value)
+	} else if c.onEvict != nil {
+		c.onEvict(key, value, ReasonShift)
+	}
+}
@@ -1 +1,2885 @@
 package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"maps"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetPromotesStale(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // shift: a, b become stale
+
+	if _, ok, stale := c.Peek("a"); !ok || !stale {
+		t.Fatalf("Peek(a) = ok %v, stale %v; want ok, stale", ok, stale)
+	}
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v; want 1, true", v, ok)
+	}
+	if _, ok, stale := c.Peek("a"); !ok || stale {
+		t.Fatalf("Peek(a) after Get = ok %v, stale %v; want ok, fresh", ok, stale)
+	}
+}
+
+func TestAddEvictsOldestGeneration(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	for i := 0; i < 4; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+	if evicted := c.Add("4", 4); !evicted {
+		t.Fatal("Add(4) did not report eviction")
+	}
+	for _, key := range []string{"0", "1"} {
+		if _, ok, _ := c.Peek(key); ok {
+			t.Errorf("key %s survived two shifts", key)
+		}
+	}
+	if n := c.Len(); n > c.MaxItems() {
+		t.Errorf("Len() = %d, exceeds MaxItems() = %d", n, c.MaxItems())
+	}
+}
+
+func TestGetOrCreate(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	calls := 0
+	create := func() int { calls++; return 7 }
+
+	if v, found, _ := c.GetOrCreate("k", create); found || v != 7 {
+		t.Fatalf("GetOrCreate miss = %v, %v; want 7, false", v, found)
+	}
+	if v, found, _ := c.GetOrCreate("k", create); !found || v != 7 {
+		t.Fatalf("GetOrCreate hit = %v, %v; want 7, true", v, found)
+	}
+	if calls != 1 {
+		t.Errorf("create called %d times, want 1", calls)
+	}
+}
+
+func TestGetOrCreateNilCreate(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	if v, found, _ := c.GetOrCreate("k", nil); found || v != 0 {
+		t.Fatalf("GetOrCreate(nil) miss = %v, %v; want 0, false", v, found)
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("GetOrCreate(nil) stored an entry; Len() = %d", n)
+	}
+	c.Add("k", 5)
+	if v, found, _ := c.GetOrCreate("k", nil); !found || v != 5 {
+		t.Fatalf("GetOrCreate(nil) hit = %v, %v; want 5, true", v, found)
+	}
+}
+
+func TestGetOrCreateCond(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	if v, found, _ := c.GetOrCreateCond("k", func() (int, bool) { return -1, false }); found || v != -1 {
+		t.Fatalf("vetoed GetOrCreateCond = %v, %v; want -1, false", v, found)
+	}
+	if _, ok, _ := c.Peek("k"); ok {
+		t.Fatal("vetoed value was cached")
+	}
+	c.GetOrCreateCond("k", func() (int, bool) { return 1, true })
+	if v, ok, _ := c.Peek("k"); !ok || v != 1 {
+		t.Fatalf("Peek(k) = %v, %v; want 1, true", v, ok)
+	}
+}
+
+func TestGetOrDefault(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	if v, found := c.GetOrDefault("k", 3); found || v != 3 {
+		t.Fatalf("GetOrDefault miss = %v, %v; want 3, false", v, found)
+	}
+	if v, found := c.GetOrDefault("k", 9); !found || v != 3 {
+		t.Fatalf("GetOrDefault hit = %v, %v; want 3, true", v, found)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("a", 1)
+	if !c.Remove("a") {
+		t.Fatal("Remove(a) = false, want true")
+	}
+	if c.Remove("a") {
+		t.Fatal("second Remove(a) = true, want false")
+	}
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("Get(a) hit after Remove")
+	}
+}
+
+func TestCompactPreventsEviction(t *testing.T) {
+	c := NewBoundedCache[int](8) // fresh cap 4
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Add(key, 0) // adding e shifts a..d to stale
+	}
+	c.Remove("b")
+	c.Remove("c")
+	c.Remove("d")
+
+	if !c.Compact() {
+		t.Fatal("Compact() = false, want true")
+	}
+	if _, ok, stale := c.Peek("a"); !ok || stale {
+		t.Fatalf("Peek(a) after Compact = ok %v, stale %v; want ok, fresh", ok, stale)
+	}
+
+	// Fresh now holds a, e; two more inserts fill it and a third shifts, but
+	// nothing is discarded because the stale map was emptied.
+	for _, key := range []string{"f", "g", "h"} {
+		if c.Add(key, 0) {
+			t.Fatalf("Add(%s) evicted entries after Compact", key)
+		}
+	}
+	if _, ok, _ := c.Peek("a"); !ok {
+		t.Fatal("a was evicted after Compact")
+	}
+}
+
+func TestCompactRequiresRoom(t *testing.T) {
+	c := NewBoundedCache[int](4) // fresh cap 2
+	c.Add("a", 0)
+	c.Add("b", 0)
+	c.Add("c", 0)
+	if c.Compact() {
+		t.Fatal("Compact() = true with entries exceeding the fresh cap")
+	}
+}
+
+func TestHighWatermark(t *testing.T) {
+	fired := make(chan struct{}, 10)
+	c := NewBoundedCache(10,
+		WithHighWatermark[int](0.5, func() { fired <- struct{}{} }),
+		WithLowWatermark[int](0.2),
+	)
+	expectFired := func(want bool) {
+		t.Helper()
+		select {
+		case <-fired:
+			if !want {
+				t.Fatal("watermark hook fired unexpectedly")
+			}
+		case <-time.After(50 * time.Millisecond):
+			if want {
+				t.Fatal("watermark hook did not fire")
+			}
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+	expectFired(false)
+	c.Add("5", 5) // 6/10 crosses 0.5
+	expectFired(true)
+	c.Add("6", 6)
+	expectFired(false)
+
+	for i := 0; i < 7; i++ {
+		c.Remove(strconv.Itoa(i))
+	}
+	c.Add("a", 0) // 1/10 re-arms
+	for _, key := range []string{"b", "c", "d", "e", "f"} {
+		c.Add(key, 0)
+	}
+	expectFired(true)
+}
+
+type zeroStruct struct {
+	n int
+	s string
+}
+
+func testZeroOnMiss[V any](t *testing.T, present V) {
+	t.Helper()
+	var zero V
+	isZero := func(v V) bool { return any(v) == any(zero) }
+	c := NewBoundedCache[V](4)
+	c.Add("present", present)
+
+	if v, ok, _ := c.Get("missing"); ok || !isZero(v) {
+		t.Errorf("Get miss = %v, %v; want zero value", v, ok)
+	}
+	if v, ok, _ := c.Peek("missing"); ok || !isZero(v) {
+		t.Errorf("Peek miss = %v, %v; want zero value", v, ok)
+	}
+	if v, found, _ := c.GetOrCreate("created", func() V { return zero }); found || !isZero(v) {
+		t.Errorf("GetOrCreate miss = %v, %v; want zero value", v, found)
+	}
+}
+
+func TestZeroValueOnMiss(t *testing.T) {
+	t.Run("pointer", func(t *testing.T) { testZeroOnMiss(t, new(int)) })
+	t.Run("struct", func(t *testing.T) { testZeroOnMiss(t, zeroStruct{1, "x"}) })
+	t.Run("interface", func(t *testing.T) { testZeroOnMiss[any](t, "x") })
+}
+
+func benchmarkFill(b *testing.B, opts ...Option[int]) {
+	const maxItems = 10000
+	keys := make([]string, maxItems/2)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := NewBoundedCache(maxItems, opts...)
+		for j, key := range keys {
+			c.Add(key, j)
+		}
+	}
+}
+
+func BenchmarkFill(b *testing.B) {
+	b.Run("unsized", func(b *testing.B) { benchmarkFill(b) })
+	b.Run("presized", func(b *testing.B) { benchmarkFill(b, WithInitialCapacity[int](5000)) })
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("k", 1)
+
+	v, version, ok := c.GetVersioned("k")
+	if !ok || v != 1 {
+		t.Fatalf("GetVersioned = %v, %v; want 1, true", v, ok)
+	}
+	if !c.CompareAndSwap("k", version, 2) {
+		t.Fatal("CompareAndSwap with current version failed")
+	}
+	if c.CompareAndSwap("k", version, 3) {
+		t.Fatal("CompareAndSwap with outdated version succeeded")
+	}
+	if v, _, _ := c.Peek("k"); v != 2 {
+		t.Fatalf("Peek = %v, want 2", v)
+	}
+}
+
+func TestCompareAndSwapAfterEviction(t *testing.T) {
+	c := NewBoundedCache[int](2) // fresh cap 1
+	c.Add("k", 1)
+	_, version, _ := c.GetVersioned("k")
+
+	c.Add("a", 0)
+	c.Add("b", 0) // k is discarded
+	if c.CompareAndSwap("k", version, 2) {
+		t.Fatal("CompareAndSwap succeeded on an evicted key")
+	}
+
+	c.Add("k", 1) // re-added keys get a fresh version
+	if c.CompareAndSwap("k", version, 2) {
+		t.Fatal("CompareAndSwap succeeded with a version from before eviction")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	for i := 0; i < 3; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+
+	drained := map[string]int{}
+	c.Drain(func(key string, value int) { drained[key] = value })
+	if len(drained) != 3 {
+		t.Fatalf("Drain yielded %d entries, want 3", len(drained))
+	}
+	for i := 0; i < 3; i++ {
+		if v := drained[strconv.Itoa(i)]; v != i {
+			t.Errorf("drained[%d] = %d", i, v)
+		}
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("Len() after Drain = %d, want 0", n)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	c := NewBoundedCache(10,
+		WithInitialCapacity[int](100),
+		WithHighWatermark[int](0.8, func() {}),
+	)
+	want := Config{MaxItems: 10, InitialCapacity: 5, HighWatermark: 0.8, LowWatermark: 0.8}
+	if got := c.Options(); got != want {
+		t.Fatalf("Options() = %+v, want %+v", got, want)
+	}
+	if r := c.FreshRatio(); r != 0.5 {
+		t.Fatalf("FreshRatio() = %v, want 0.5", r)
+	}
+}
+
+func TestUnsafeBoundedCache(t *testing.T) {
+	c := NewUnsafeBoundedCache[int](4)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3)
+	if _, ok, stale := c.Peek("a"); !ok || !stale {
+		t.Fatalf("Peek(a) = ok %v, stale %v; want ok, stale", ok, stale)
+	}
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v; want 1, true", v, ok)
+	}
+}
+
+func benchmarkGetHit(b *testing.B, c *BoundedCache[int]) {
+	keys := make([]string, c.MaxItems()/2)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		c.Add(keys[i], i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.Get(keys[i%len(keys)])
+	}
+}
+
+func BenchmarkGetHit(b *testing.B) {
+	b.Run("locked", func(b *testing.B) { benchmarkGetHit(b, NewBoundedCache[int](1000)) })
+	b.Run("unsafe", func(b *testing.B) { benchmarkGetHit(b, NewUnsafeBoundedCache[int](1000)) })
+}
+
+func TestEventHook(t *testing.T) {
+	var events []Event
+	c := NewBoundedCache(2, WithEventHook[int](func(e Event) { events = append(events, e) }))
+
+	c.GetOrCreate("a", func() int { return 1 }) // miss, create
+	c.Get("a")                                  // hit
+	c.Add("b", 2)                               // shift
+	c.Add("c", 3)                               // shift, evict a
+
+	want := []struct {
+		typ EventType
+		key string
+	}{
+		{EventMiss, "a"},
+		{EventCreate, "a"},
+		{EventHit, "a"},
+		{EventShift, ""},
+		{EventShift, ""},
+		{EventEvict, "a"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events %v, want %d", len(events), events, len(want))
+	}
+	for i, w := range want {
+		if events[i].Type != w.typ || events[i].Key != w.key {
+			t.Errorf("event %d = %v %q, want %v %q", i, events[i].Type, events[i].Key, w.typ, w.key)
+		}
+		if events[i].Time.IsZero() {
+			t.Errorf("event %d has no timestamp", i)
+		}
+	}
+}
+
+func TestOrderedIteration(t *testing.T) {
+	c := NewBoundedCache(6, WithOrderedIteration[int]()) // fresh cap 3
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Add(key, i) // d shifts a, b, c to stale
+	}
+	c.Get("b")    // promotion keeps b's position
+	c.Add("a", 9) // so does an overwrite, even one that shifts and drops c
+	c.Add("f", 0)
+
+	want := []string{"a", "b", "d", "e", "f"}
+	for i := 0; i < 3; i++ {
+		if got := c.Keys(); !slices.Equal(got, want) {
+			t.Fatalf("Keys() = %v, want %v", got, want)
+		}
+	}
+
+	c.Remove("d")
+	c.Add("d", 0) // re-adding after removal moves d to the end
+	var got []string
+	c.Range(func(key string, _ int) bool {
+		got = append(got, key)
+		return true
+	})
+	if want := []string{"a", "b", "e", "f", "d"}; !slices.Equal(got, want) {
+		t.Fatalf("Range order = %v, want %v", got, want)
+	}
+}
+
+func TestPeekStatus(t *testing.T) {
+	c := NewBoundedCache[int](4) // fresh cap 2
+	c.Add("a", 0)
+	if _, s := c.PeekStatus("a"); s != StatusFresh {
+		t.Fatalf("PeekStatus(a) = %v, want fresh", s)
+	}
+	c.Add("b", 0)
+	c.Add("c", 0) // a, b become stale
+	if _, s := c.PeekStatus("a"); s != StatusStale {
+		t.Fatalf("PeekStatus(a) = %v, want stale", s)
+	}
+	c.Add("d", 0) // fresh is full again
+	if _, s := c.PeekStatus("a"); s != StatusAboutToDrop {
+		t.Fatalf("PeekStatus(a) = %v, want about-to-drop", s)
+	}
+	if _, s := c.PeekStatus("missing"); s != StatusAbsent {
+		t.Fatalf("PeekStatus(missing) = %v, want absent", s)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	c := NewBoundedCache[int](100)
+	errBad := errors.New("bad key")
+	keys := []string{"1", "2", "bad", "3"}
+	err := c.Warm(keys, func(key string) (int, error) {
+		if key == "bad" {
+			return 0, errBad
+		}
+		return strconv.Atoi(key)
+	}, 2)
+	if !errors.Is(err, errBad) {
+		t.Fatalf("Warm() error = %v, want %v", err, errBad)
+	}
+	for _, key := range []string{"1", "2", "3"} {
+		if _, ok, _ := c.Peek(key); !ok {
+			t.Errorf("key %s was not warmed", key)
+		}
+	}
+	if _, ok, _ := c.Peek("bad"); ok {
+		t.Error("failed key was cached")
+	}
+}
+
+func TestWarmUnsafe(t *testing.T) {
+	c := NewUnsafeBoundedCache[int](100)
+	keys := make([]string, 50)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	if err := c.Warm(keys, strconv.Atoi, 8); err != nil {
+		t.Fatal(err)
+	}
+	if c.Len() != len(keys) {
+		t.Fatalf("Len = %d after Warm, want %d", c.Len(), len(keys))
+	}
+}
+
+func TestGetOrCreateInto(t *testing.T) {
+	c := NewBoundedCache[[]int](4)
+	v, found, _ := c.GetOrCreateInto("k", func(dst *[]int) {
+		*dst = append(*dst, 1, 2)
+	})
+	if found || !slices.Equal(v, []int{1, 2}) {
+		t.Fatalf("GetOrCreateInto miss = %v, %v; want [1 2], false", v, found)
+	}
+	if v, ok, _ := c.Peek("k"); !ok || !slices.Equal(v, []int{1, 2}) {
+		t.Fatalf("Peek(k) = %v, %v; want [1 2], true", v, ok)
+	}
+}
+
+type bigValue struct {
+	fields [16]int64
+}
+
+func BenchmarkGetOrCreateMiss(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	b.Run("closure", func(b *testing.B) {
+		c := NewBoundedCache[bigValue](16)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.GetOrCreate(keys[i%len(keys)], func() bigValue {
+				var v bigValue
+				v.fields[0] = int64(i)
+				return v
+			})
+		}
+	})
+	b.Run("into", func(b *testing.B) {
+		c := NewBoundedCache[bigValue](16)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.GetOrCreateInto(keys[i%len(keys)], func(dst *bigValue) {
+				dst.fields[0] = int64(i)
+			})
+		}
+	})
+	b.Run("key", func(b *testing.B) {
+		c := NewBoundedCache[bigValue](16)
+		create := func(key string) bigValue {
+			var v bigValue
+			v.fields[0] = int64(len(key))
+			return v
+		}
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			c.GetOrCreateKey(keys[i%len(keys)], create)
+		}
+	})
+}
+
+func TestGetOrCreateKey(t *testing.T) {
+	c := NewBoundedCache[string](10)
+	var seen []string
+	create := func(key string) string {
+		seen = append(seen, key)
+		return "v:" + key
+	}
+	if v, found, _ := c.GetOrCreateKey("a", create); v != "v:a" || found {
+		t.Fatalf("GetOrCreateKey(a) = %q, %v; want v:a created", v, found)
+	}
+	if v, found, _ := c.GetOrCreateKey("a", create); v != "v:a" || !found {
+		t.Fatalf("second GetOrCreateKey(a) = %q, %v; want cached v:a", v, found)
+	}
+	if !slices.Equal(seen, []string{"a"}) {
+		t.Fatalf("create saw %v, want [a]", seen)
+	}
+}
+
+// fakeClock is a manually advanced clock for WithClock.
+type fakeClock struct {
+	t time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Unix(1_700_000_000, 0)}
+}
+
+func (f *fakeClock) Now() time.Time          { return f.t }
+func (f *fakeClock) Advance(d time.Duration) { f.t = f.t.Add(d) }
+
+func TestGetOrCreateNeg(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(4,
+		WithNegativeTTL[int](time.Minute),
+		WithClock[int](clock.Now),
+	)
+	calls := 0
+	notFound := func() (int, bool) { calls++; return 0, false }
+
+	if _, ok := c.GetOrCreateNeg("k", notFound); ok {
+		t.Fatal("GetOrCreateNeg reported a hit for a not-found key")
+	}
+	if _, ok := c.GetOrCreateNeg("k", notFound); ok || calls != 1 {
+		t.Fatalf("negative result not cached: ok %v, calls %d", ok, calls)
+	}
+
+	clock.Advance(time.Minute)
+	c.GetOrCreateNeg("k", notFound)
+	if calls != 2 {
+		t.Fatalf("negative result outlived its TTL: calls %d", calls)
+	}
+
+	c.Add("k", 7)
+	if v, ok := c.GetOrCreateNeg("k", notFound); !ok || v != 7 {
+		t.Fatalf("GetOrCreateNeg after Add = %v, %v; want 7, true", v, ok)
+	}
+}
+
+func TestGetOrCreateNegNilCreate(t *testing.T) {
+	c := NewBoundedCache(4, WithNegativeTTL[int](time.Minute))
+	if v, ok := c.GetOrCreateNeg("k", nil); ok || v != 0 {
+		t.Fatalf("GetOrCreateNeg(k, nil) on a miss = %v, %v; want 0, false", v, ok)
+	}
+	if c.Len() != 0 {
+		t.Fatal("GetOrCreateNeg with a nil create stored a value")
+	}
+	c.Add("k", 7)
+	if v, ok := c.GetOrCreateNeg("k", nil); !ok || v != 7 {
+		t.Fatalf("GetOrCreateNeg(k, nil) on a hit = %v, %v; want 7, true", v, ok)
+	}
+}
+
+func TestEstimatedBytes(t *testing.T) {
+	c := NewBoundedCache[[]byte](10)
+	if n := c.EstimatedBytes(); n != 0 {
+		t.Fatalf("EstimatedBytes() of empty cache = %d, want 0", n)
+	}
+	c.Add("a", make([]byte, 1000))
+	base := c.EstimatedBytes()
+	if base <= 1 {
+		t.Fatalf("EstimatedBytes() = %d, want map slot overhead", base)
+	}
+
+	sized := NewBoundedCache(10, WithSizeEstimator(func(key string, v []byte) int64 {
+		return int64(len(key) + cap(v))
+	}))
+	sized.Add("a", make([]byte, 1000))
+	if n := sized.EstimatedBytes(); n != base+1000 {
+		t.Fatalf("EstimatedBytes() with estimator = %d, want %d", n, base+1000)
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("a", 1)
+	gen := c.Generation()
+
+	c.Purge()
+	if c.Len() != 0 {
+		t.Fatalf("Len() after Purge = %d, want 0", c.Len())
+	}
+	if c.Generation() == gen {
+		t.Fatal("Purge did not advance Generation")
+	}
+
+	gen = c.Generation()
+	c.ReplaceAll(map[string]int{"b": 2, "c": 3})
+	if c.Generation() == gen {
+		t.Fatal("ReplaceAll did not advance Generation")
+	}
+	if keys := c.Keys(); len(keys) != 2 {
+		t.Fatalf("Keys() after ReplaceAll = %v", keys)
+	}
+}
+
+func TestByteCacheGetOrCreateStream(t *testing.T) {
+	c := NewByteCache(4)
+	calls := 0
+	produce := func(w io.Writer) error {
+		calls++
+		_, err := io.WriteString(w, "payload")
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		b, err := c.GetOrCreateStream("k", produce)
+		if err != nil || string(b) != "payload" {
+			t.Fatalf("GetOrCreateStream = %q, %v", b, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("produce called %d times, want 1", calls)
+	}
+
+	errBad := errors.New("bad")
+	if _, err := c.GetOrCreateStream("bad", func(io.Writer) error { return errBad }); err != errBad {
+		t.Fatalf("GetOrCreateStream error = %v, want %v", err, errBad)
+	}
+	if _, ok, _ := c.Peek("bad"); ok {
+		t.Fatal("failed stream was cached")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(4,
+		WithIdleTimeout[int](time.Minute),
+		WithClock[int](clock.Now),
+	)
+	c.Add("a", 1)
+	c.Add("b", 2)
+
+	clock.Advance(40 * time.Second)
+	if _, ok, _ := c.Get("a"); !ok {
+		t.Fatal("Get(a) missed before the idle timeout")
+	}
+	clock.Advance(40 * time.Second)
+	if _, ok, _ := c.Peek("a"); !ok {
+		t.Fatal("a expired although it was accessed within the idle timeout")
+	}
+	if _, ok, _ := c.Peek("b"); ok {
+		t.Fatal("Peek(b) hit after the idle timeout")
+	}
+	if keys := c.Keys(); !slices.Equal(keys, []string{"a"}) {
+		t.Fatalf("Keys() = %v, want [a]", keys)
+	}
+
+	clock.Advance(time.Minute)
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("Get(a) hit after the idle timeout")
+	}
+}
+
+func TestOccupancy(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Add(key, 0)
+	}
+	if fresh, stale, max := c.Occupancy(); fresh != 1 || stale != 2 || max != 2 {
+		t.Fatalf("Occupancy() = %d, %d, %d; want 1, 2, 2", fresh, stale, max)
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("old", 0)
+	c.BulkLoad(func(set func(string, int)) {
+		for i := 0; i < 6; i++ {
+			set(strconv.Itoa(i), i)
+		}
+		set("1", 10) // re-setting makes 1 the most recent entry
+	})
+
+	if n := c.Len(); n != 4 {
+		t.Fatalf("Len() after BulkLoad = %d, want 4", n)
+	}
+	for _, key := range []string{"1", "5"} {
+		if _, ok, stale := c.Peek(key); !ok || stale {
+			t.Errorf("Peek(%s) = ok %v, stale %v; want fresh", key, ok, stale)
+		}
+	}
+	for _, key := range []string{"3", "4"} {
+		if _, ok, stale := c.Peek(key); !ok || !stale {
+			t.Errorf("Peek(%s) = ok %v, stale %v; want stale", key, ok, stale)
+		}
+	}
+	for _, key := range []string{"old", "0", "2"} {
+		if _, ok, _ := c.Peek(key); ok {
+			t.Errorf("%s survived the bulk load", key)
+		}
+	}
+	if v, _, _ := c.Peek("1"); v != 10 {
+		t.Errorf("Peek(1) = %d, want the last value set", v)
+	}
+}
+
+func TestBulkLoadDiscard(t *testing.T) {
+	victim := NewBoundedCache[int](8)
+	var evicts []string
+	c := NewBoundedCache(4,
+		WithVictimCache[int](victim),
+		WithEventHook[int](func(ev Event) {
+			if ev.Type == EventEvict {
+				evicts = append(evicts, ev.Key)
+			}
+		}),
+	)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.BulkLoad(func(set func(string, int)) {
+		for _, key := range []string{"w", "x", "y", "z"} {
+			set(key, 0)
+		}
+	})
+
+	slices.Sort(evicts)
+	if want := []string{"a", "b"}; !slices.Equal(evicts, want) {
+		t.Fatalf("evicted %v, want %v", evicts, want)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok, _ := victim.Peek(key); !ok {
+			t.Errorf("%s displaced by BulkLoad was not spilled to the victim cache", key)
+		}
+	}
+}
+
+func TestPeekMany(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a, b become stale
+
+	got := c.PeekMany([]string{"a", "c", "missing"})
+	want := map[string]PeekResult[int]{
+		"a": {Value: 1, Stale: true},
+		"c": {Value: 3, Stale: false},
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("PeekMany() = %v, want %v", got, want)
+	}
+	if _, ok, stale := c.Peek("a"); !ok || !stale {
+		t.Fatal("PeekMany promoted a stale entry")
+	}
+}
+
+func TestAddTo(t *testing.T) {
+	c := NewBoundedCache[int](4) // per-generation cap 2
+	c.AddTo("hot", 1, false)
+	c.AddTo("cold", 2, true)
+	if _, ok, stale := c.Peek("hot"); !ok || stale {
+		t.Fatal("AddTo(hot, fresh) did not store in the fresh generation")
+	}
+	if _, ok, stale := c.Peek("cold"); !ok || !stale {
+		t.Fatal("AddTo(cold, stale) did not store in the stale generation")
+	}
+
+	c.AddTo("hot", 3, true) // moves hot, never duplicating it
+	if fresh, stale, _ := c.Occupancy(); fresh != 0 || stale != 2 {
+		t.Fatalf("Occupancy() = %d fresh, %d stale; want 0, 2", fresh, stale)
+	}
+	if evicted := c.AddTo("colder", 4, true); !evicted {
+		t.Fatal("AddTo into a full stale generation did not evict")
+	}
+	if n := c.Len(); n != 2 {
+		t.Fatalf("Len() = %d, want 2", n)
+	}
+}
+
+func TestOnEvictReasons(t *testing.T) {
+	clock := newFakeClock()
+	got := map[string]EvictReason{}
+	c := NewBoundedCache(4,
+		WithOnEvict(func(key string, _ int, reason EvictReason) { got[key] = reason }),
+		WithIdleTimeout[int](time.Minute),
+		WithClock[int](clock.Now),
+	)
+
+	c.Add("replaced", 1)
+	c.Add("replaced", 2)
+	c.Add("removed", 0)
+	c.Remove("removed")
+	c.Add("shifted", 0)
+	c.Add("a", 0) // shift: replaced, shifted become stale
+	c.Add("b", 0)
+	c.Add("c", 0) // shift: replaced, shifted are discarded
+	clock.Advance(time.Minute)
+	c.Get("a")
+	clock.Advance(-time.Minute)
+	c.Add("purged", 0)
+	c.Purge()
+
+	want := map[string]EvictReason{
+		"replaced": ReasonShift,
+		"removed":  ReasonRemove,
+		"shifted":  ReasonShift,
+		"a":        ReasonExpired,
+		"b":        ReasonPurge,
+		"c":        ReasonPurge,
+		"purged":   ReasonPurge,
+	}
+	if !maps.Equal(got, want) {
+		t.Fatalf("OnEvict reasons = %v, want %v", got, want)
+	}
+}
+
+func TestOnEvictReplace(t *testing.T) {
+	type eviction struct {
+		value  int
+		reason EvictReason
+	}
+	var got []eviction
+	c := NewBoundedCache(4, WithOnEvict(func(_ string, v int, reason EvictReason) {
+		got = append(got, eviction{v, reason})
+	}))
+	c.Add("k", 1)
+	c.Add("k", 2)
+	c.ReplaceAll(nil)
+	c.Add("d", 3)
+	c.Drain(func(string, int) {})
+
+	want := []eviction{{1, ReasonReplace}, {2, ReasonReplace}}
+	if !slices.Equal(got, want) {
+		t.Fatalf("evictions = %v, want %v", got, want)
+	}
+}
+
+func TestNumericCacheIncrement(t *testing.T) {
+	c := NewNumericCache[int64](4)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Increment("hits", 2)
+		}()
+	}
+	wg.Wait()
+	if v, _ := c.Increment("hits", -1); v != 99 {
+		t.Fatalf("Increment() = %d, want 99", v)
+	}
+}
+
+func TestSliceCacheAppend(t *testing.T) {
+	c := NewSliceCache[string](4)
+	if n, _ := c.Append("log", "a"); n != 1 {
+		t.Fatalf("Append to a missing key = %d, want 1", n)
+	}
+	if n, _ := c.Append("log", "b", "c"); n != 3 {
+		t.Fatalf("Append = %d, want 3", n)
+	}
+	if v, _, _ := c.Peek("log"); !slices.Equal(v, []string{"a", "b", "c"}) {
+		t.Fatalf("Peek(log) = %v, want [a b c]", v)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Append("concurrent", "x")
+		}()
+	}
+	wg.Wait()
+	if v, _, _ := c.Peek("concurrent"); len(v) != 50 {
+		t.Fatalf("concurrent appends kept %d items, want 50", len(v))
+	}
+}
+
+func TestEvictionCandidates(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	for _, key := range []string{"a", "b", "c"} {
+		c.Add(key, 0)
+	}
+	got := c.EvictionCandidates()
+	slices.Sort(got)
+	if want := []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Fatalf("EvictionCandidates() = %v, want %v", got, want)
+	}
+}
+
+func TestEvictionFilter(t *testing.T) {
+	c := NewBoundedCache(6, WithEvictionFilter(func(key string, _ int) bool {
+		return strings.HasPrefix(key, "keep")
+	})) // per-generation cap 3
+	for _, key := range []string{"keep1", "keep2", "keep3", "a", "b", "c"} {
+		c.Add(key, 0)
+	}
+	// The next shift would discard keep1..3; only two fit beside the insert.
+	c.Add("d", 0)
+
+	kept := 0
+	for _, key := range []string{"keep1", "keep2", "keep3"} {
+		if _, ok, stale := c.Peek(key); ok {
+			if stale {
+				t.Errorf("%s was rescued into the stale generation", key)
+			}
+			kept++
+		}
+	}
+	if kept != 2 {
+		t.Fatalf("%d entries rescued, want 2", kept)
+	}
+	if fresh, _, max := c.Occupancy(); fresh > max {
+		t.Fatalf("fresh generation holds %d entries, over its cap of %d", fresh, max)
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	if v, set, _ := c.GetOrSet("k", 1); !set || v != 1 {
+		t.Fatalf("GetOrSet miss = %v, set %v; want 1, true", v, set)
+	}
+	if v, set, _ := c.GetOrSet("k", 2); set || v != 1 {
+		t.Fatalf("GetOrSet hit = %v, set %v; want 1, false", v, set)
+	}
+	c.Add("a", 0)
+	if _, _, evicted := c.GetOrSet("b", 0); evicted {
+		t.Fatal("GetOrSet reported eviction with an empty stale generation")
+	}
+	c.Add("c", 0)
+	if _, _, evicted := c.GetOrSet("d", 0); !evicted {
+		t.Fatal("GetOrSet did not report the eviction its store caused")
+	}
+}
+
+type fakeConn struct {
+	id     int
+	closed bool
+}
+
+func TestHealthCheck(t *testing.T) {
+	var reasons []EvictReason
+	c := NewBoundedCache(4,
+		WithHealthCheck(func(conn *fakeConn) bool { return !conn.closed }),
+		WithOnEvict(func(_ string, _ *fakeConn, reason EvictReason) { reasons = append(reasons, reason) }),
+	)
+	dial := func(id int) func() *fakeConn {
+		return func() *fakeConn { return &fakeConn{id: id} }
+	}
+
+	conn, _, _ := c.GetOrCreate("db", dial(1))
+	conn.closed = true
+	if _, ok, _ := c.Get("db"); ok {
+		t.Fatal("Get returned a value that failed the health check")
+	}
+	conn, found, _ := c.GetOrCreate("db", dial(2))
+	if found || conn.id != 2 {
+		t.Fatalf("GetOrCreate = conn %d, found %v; want a fresh conn 2", conn.id, found)
+	}
+	if !slices.Equal(reasons, []EvictReason{ReasonUnhealthy}) {
+		t.Fatalf("OnEvict reasons = %v, want [unhealthy]", reasons)
+	}
+}
+
+func TestResizeShrink(t *testing.T) {
+	var evicted []string
+	c := NewBoundedCache(8, WithOnEvict(func(key string, _ int, _ EvictReason) {
+		evicted = append(evicted, key)
+	})) // per-generation cap 4
+	for i := 0; i < 7; i++ {
+		c.Add(strconv.Itoa(i), i) // 0..3 stale, 4..6 fresh
+	}
+
+	c.Resize(4) // per-generation cap 2
+	fresh, stale, max := c.Occupancy()
+	if max != 2 || fresh != 2 || stale != 2 {
+		t.Fatalf("Occupancy() after shrink = %d, %d, %d; want 2, 2, 2", fresh, stale, max)
+	}
+	if len(evicted) != 3 {
+		t.Fatalf("shrink evicted %v, want 3 entries", evicted)
+	}
+	for _, key := range evicted {
+		if n, _ := strconv.Atoi(key); n > 3 {
+			t.Errorf("shrink evicted fresh entry %s while stale entries remained", key)
+		}
+	}
+	if c.MaxItems() != 4 || c.Options().MaxItems != 4 {
+		t.Fatalf("MaxItems() = %d, Options().MaxItems = %d; want 4", c.MaxItems(), c.Options().MaxItems)
+	}
+}
+
+func TestResizeGrow(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	for i := 0; i < 4; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+	c.Resize(20)
+	if n := c.Len(); n != 4 {
+		t.Fatalf("Len() after grow = %d, want 4", n)
+	}
+	for i := 4; i < 10; i++ {
+		if c.Add(strconv.Itoa(i), i) {
+			t.Fatalf("Add(%d) evicted after growing", i)
+		}
+	}
+}
+
+func TestRebalancePromotesIntoSpareFreshCapacity(t *testing.T) {
+	c := NewBoundedCache[int](8)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		c.AddTo(key, 0, true)
+	}
+	c.Resize(6) // per-generation cap 3: one stale entry must move to fresh
+	if fresh, stale, _ := c.Occupancy(); fresh != 1 || stale != 3 {
+		t.Fatalf("Occupancy() = %d fresh, %d stale; want 1, 3", fresh, stale)
+	}
+}
+
+func TestRejectNil(t *testing.T) {
+	c := NewBoundedCache(4, WithRejectNil[*int]())
+	if _, err := c.TryAdd("nil", nil); !errors.Is(err, ErrNilValue) {
+		t.Fatalf("TryAdd(nil) error = %v, want ErrNilValue", err)
+	}
+	c.Add("nil", nil)
+	if v, found, _ := c.GetOrCreate("nil", func() *int { return nil }); found || v != nil {
+		t.Fatalf("GetOrCreate(nil) = %v, %v", v, found)
+	}
+	if n := c.Len(); n != 0 {
+		t.Fatalf("nil values were stored: Len() = %d", n)
+	}
+	if _, err := c.TryAdd("one", new(int)); err != nil {
+		t.Fatalf("TryAdd(non-nil) error = %v", err)
+	}
+
+	var nilPtr *int
+	iface := NewBoundedCache(4, WithRejectNil[any]())
+	iface.Add("typed nil", nilPtr)
+	iface.Add("nil", nil)
+	iface.Add("nil slice", []int(nil))
+	if keys := iface.Keys(); !slices.Equal(keys, []string{"nil slice"}) {
+		t.Fatalf("Keys() = %v, want only the nil slice", keys)
+	}
+}
+
+func TestOnPromote(t *testing.T) {
+	var promoted []string
+	c := NewBoundedCache(4, WithOnPromote(func(key string, _ int) {
+		promoted = append(promoted, key)
+	}))
+	c.Add("a", 0)
+	c.Add("b", 0)
+	c.Get("b") // fresh hit
+	c.Add("c", 0)
+	c.Get("a") // stale hit
+	c.GetOrCreate("b", func() int { return 0 })
+	c.Peek("a")
+
+	if want := []string{"a", "b"}; !slices.Equal(promoted, want) {
+		t.Fatalf("promoted = %v, want %v", promoted, want)
+	}
+}
+
+func TestGetGroup(t *testing.T) {
+	c := NewBoundedCache[int](10)
+	c.Add("user:1:name", 1)
+	c.Add("user:1:email", 2)
+	c.Add("user:2:name", 3)
+	got := c.GetGroup("user:1:")
+	want := map[string]int{"user:1:name": 1, "user:1:email": 2}
+	if !maps.Equal(got, want) {
+		t.Fatalf("GetGroup() = %v, want %v", got, want)
+	}
+}
+
+func TestVictimCache(t *testing.T) {
+	victim := NewBoundedCache[int](10)
+	c := NewBoundedCache(2, WithVictimCache[int](victim)) // per-generation cap 1
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a is discarded into the victim cache
+
+	if _, ok, _ := c.Peek("a"); ok {
+		t.Fatal("a is still in the primary cache")
+	}
+	if v, ok, _ := victim.Peek("a"); !ok || v != 1 {
+		t.Fatalf("victim.Peek(a) = %v, %v; want 1, true", v, ok)
+	}
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v; want 1 from the victim cache", v, ok)
+	}
+	if _, ok, stale := c.Peek("a"); !ok || stale {
+		t.Fatal("victim hit was not moved into the primary's fresh generation")
+	}
+	if _, ok, _ := victim.Peek("a"); ok {
+		t.Fatal("victim hit was left in the victim cache")
+	}
+}
+
+func TestKeysFunc(t *testing.T) {
+	c := NewBoundedCache[int](10, WithOrderedIteration[int]())
+	for i, k := range []string{"user:1", "item:1", "user:2", "user:3"} {
+		c.Add(k, i)
+	}
+	isUser := func(k string) bool { return strings.HasPrefix(k, "user:") }
+
+	got := slices.Collect(c.KeysFunc(isUser))
+	if want := []string{"user:1", "user:2", "user:3"}; !slices.Equal(got, want) {
+		t.Fatalf("KeysFunc = %v, want %v", got, want)
+	}
+
+	var first []string
+	for k := range c.KeysFunc(isUser) {
+		first = append(first, k)
+		break
+	}
+	if len(first) != 1 {
+		t.Fatalf("iteration continued after break: %v", first)
+	}
+	c.Add("user:4", 4) // the lock was released by the break
+}
+
+func TestCopyOnRead(t *testing.T) {
+	var c *BoundedCache[[]int]
+	unlocked := true
+	c = NewBoundedCache(10, WithCopyOnRead(func(v []int) []int {
+		if !c.lock.(*sync.RWMutex).TryLock() {
+			unlocked = false
+		} else {
+			c.lock.Unlock()
+		}
+		return slices.Clone(v)
+	}))
+	c.Add("a", []int{1, 2})
+
+	mutate := func(name string, v []int) {
+		t.Helper()
+		v[0] = 99
+		if got, _, _ := c.Peek("a"); got[0] != 1 {
+			t.Fatalf("%s returned the stored slice", name)
+		}
+	}
+	v, _, _ := c.Get("a")
+	mutate("Get", v)
+	v, _, _ = c.Peek("a")
+	mutate("Peek", v)
+	v, _, _ = c.GetOrCreate("a", func() []int { return nil })
+	mutate("GetOrCreate", v)
+	v, _, _ = c.GetVersioned("a")
+	mutate("GetVersioned", v)
+	mutate("PeekMany", c.PeekMany([]string{"a"})["a"].Value)
+	mutate("GetGroup", c.GetGroup("")["a"])
+
+	if !unlocked {
+		t.Fatal("copy function ran with the lock held")
+	}
+}
+
+func TestStaleHitRatio(t *testing.T) {
+	c := NewBoundedCache[int](4) // per-generation cap 2
+	if r := c.StaleHitRatio(); r != 0 {
+		t.Fatalf("StaleHitRatio before any hit = %v, want 0", r)
+	}
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b are now stale
+
+	c.Get("a")       // stale hit, promoted
+	c.Get("a")       // fresh hit
+	c.Get("c")       // fresh hit
+	c.Get("missing") // misses do not count
+	c.Peek("b")      // peeks do not count
+	if r := c.StaleHitRatio(); r != 1.0/3 {
+		t.Fatalf("StaleHitRatio = %v, want 1/3", r)
+	}
+}
+
+func TestBeginCreate(t *testing.T) {
+	c := NewBoundedCache[int](10)
+	c.Add("a", 1)
+	v, ok, done := c.BeginCreate("a")
+	if !ok || v != 1 {
+		t.Fatalf("BeginCreate(a) = %v, %v; want 1, true", v, ok)
+	}
+	done(5)
+	if v, _, _ := c.Peek("a"); v != 1 {
+		t.Fatalf("done on a hit overwrote a: got %v", v)
+	}
+
+	_, ok, done = c.BeginCreate("b")
+	if ok {
+		t.Fatal("BeginCreate(b) hit on an empty key")
+	}
+	done(2)
+	done(3) // only the first call stores
+	if v, _, _ := c.Peek("b"); v != 2 {
+		t.Fatalf("Peek(b) = %v, want 2", v)
+	}
+
+	_, _, done = c.BeginCreate("c")
+	c.Add("c", 30) // a concurrent writer gets there first
+	done(3)
+	if v, _, _ := c.Peek("c"); v != 30 {
+		t.Fatalf("done overwrote a concurrent insert: got %v", v)
+	}
+}
+
+func TestAddWithPriority(t *testing.T) {
+	c := NewBoundedCache[int](8) // per-generation cap 4, priority budget 1
+	c.AddWithPriority("hi", 1, 5)
+	c.AddWithPriority("mid", 2, 1)
+	c.Add("a", 3)
+	c.Add("b", 4)
+	c.Add("c", 5) // shift: hi, mid, a and b become stale
+	for _, k := range []string{"d", "e", "f", "g"} {
+		c.Add(k, 0) // g shifts again, discarding the old stale generation
+	}
+
+	if _, ok, stale := c.Peek("hi"); !ok || stale {
+		t.Fatalf("Peek(hi) = ok %v, stale %v; want it carried into fresh", ok, stale)
+	}
+	for _, k := range []string{"mid", "a", "b"} {
+		if _, ok, _ := c.Peek(k); ok {
+			t.Fatalf("%s survived the shift beyond the priority budget", k)
+		}
+	}
+
+	c.Add("hi", 10) // a plain write resets the priority
+	for _, k := range []string{"h", "i", "j", "k", "l", "m", "n"} {
+		c.Add(k, 0)
+	}
+	if _, ok, _ := c.Peek("hi"); ok {
+		t.Fatal("hi was carried over after its priority was reset")
+	}
+}
+
+func TestRecreateAndReturnOld(t *testing.T) {
+	var replaced []int
+	c := NewBoundedCache(10, WithOnEvict(func(_ string, v int, reason EvictReason) {
+		if reason == ReasonReplace {
+			replaced = append(replaced, v)
+		}
+	}))
+	merge := func(old int, existed bool) int {
+		if !existed {
+			return 1
+		}
+		return old * 10
+	}
+
+	newVal, oldVal, existed, _ := c.RecreateAndReturnOld("a", merge)
+	if newVal != 1 || oldVal != 0 || existed {
+		t.Fatalf("first call = %v, %v, %v; want 1, 0, false", newVal, oldVal, existed)
+	}
+	newVal, oldVal, existed, _ = c.RecreateAndReturnOld("a", merge)
+	if newVal != 10 || oldVal != 1 || !existed {
+		t.Fatalf("second call = %v, %v, %v; want 10, 1, true", newVal, oldVal, existed)
+	}
+	if v, _, _ := c.Peek("a"); v != 10 {
+		t.Fatalf("Peek(a) = %v, want 10", v)
+	}
+	if !slices.Equal(replaced, []int{1}) {
+		t.Fatalf("replaced values = %v, want [1]", replaced)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	calls := 0
+	c := NewBoundedCache(10, WithDefaultCreate(func(key string) int {
+		calls++
+		return len(key)
+	}))
+	if v, found, _ := c.Fetch("abc"); v != 3 || found {
+		t.Fatalf("Fetch(abc) = %v, %v; want 3 created", v, found)
+	}
+	if v, found, _ := c.Fetch("abc"); v != 3 || !found || calls != 1 {
+		t.Fatalf("second Fetch(abc) = %v, %v after %d calls; want a cached 3", v, found, calls)
+	}
+
+	plain := NewBoundedCache[int](10)
+	if _, found, _ := plain.Fetch("abc"); found || plain.Len() != 0 {
+		t.Fatal("Fetch without a default creator created a value")
+	}
+}
+
+func TestTeardownOrder(t *testing.T) {
+	var torn []string
+	c := NewBoundedCache(4,
+		WithOnEvict(func(key string, _ int, reason EvictReason) {
+			if reason == ReasonPurge {
+				torn = append(torn, key)
+			}
+		}),
+		WithTeardownOrder(func(values []int) []int {
+			slices.Sort(values)
+			return values[:len(values)-1] // leave the largest out
+		}),
+	)
+	c.Add("c", 3)
+	c.Add("a", 1)
+	c.Add("b", 2) // shift: c and a become stale
+	c.Add("z", 9)
+
+	c.Purge()
+	if want := []string{"a", "b", "c", "z"}; !slices.Equal(torn, want) {
+		t.Fatalf("purge order = %v, want %v", torn, want)
+	}
+}
+
+func TestTopN(t *testing.T) {
+	c := NewBoundedCache[int](20)
+	for i, v := range []int{5, 1, 9, 3, 7, 2, 8} {
+		c.Add(strconv.Itoa(i), v)
+	}
+	less := func(a, b int) bool { return a < b }
+	if got, want := c.TopN(3, less), []int{9, 8, 7}; !slices.Equal(got, want) {
+		t.Fatalf("TopN(3) = %v, want %v", got, want)
+	}
+	if got := c.TopN(100, less); len(got) != 7 || got[6] != 1 {
+		t.Fatalf("TopN(100) = %v, want all 7 values", got)
+	}
+	if got := c.TopN(0, less); got != nil {
+		t.Fatalf("TopN(0) = %v, want nil", got)
+	}
+}
+
+func TestLiveLen(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(10, WithClock[int](clock.Now), WithIdleTimeout[int](time.Minute))
+	c.Add("a", 1)
+	clock.Advance(30 * time.Second)
+	c.Add("b", 2)
+	clock.Advance(45 * time.Second) // a is idle past the timeout, b is not
+
+	if n := c.Len(); n != 2 {
+		t.Fatalf("Len = %d, want 2 including the unswept entry", n)
+	}
+	if n := c.LiveLen(); n != 1 {
+		t.Fatalf("LiveLen = %d, want 1", n)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	c := NewBoundedCache[int](10)
+	users, items := c.WithKeyPrefix("user:"), c.WithKeyPrefix("item:")
+	users.Add("1", 10)
+	items.Add("1", 20)
+
+	if v, ok, _ := users.Get("1"); !ok || v != 10 {
+		t.Fatalf("users.Get(1) = %v, %v; want 10", v, ok)
+	}
+	if v, ok, _ := items.Peek("1"); !ok || v != 20 {
+		t.Fatalf("items.Peek(1) = %v, %v; want 20", v, ok)
+	}
+	if _, ok, _ := c.Peek("user:1"); !ok {
+		t.Fatal("the shared cache does not hold the prefixed key")
+	}
+	if got := users.Keys(); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("users.Keys = %v, want [1]", got)
+	}
+	if got := users.StoredKeys(); !slices.Equal(got, []string{"user:1"}) {
+		t.Fatalf("users.StoredKeys = %v, want [user:1]", got)
+	}
+
+	users.Remove("1")
+	if users.Len() != 0 || items.Len() != 1 {
+		t.Fatalf("after Remove: users.Len = %d, items.Len = %d; want 0, 1", users.Len(), items.Len())
+	}
+}
+
+func TestLockFreeReads(t *testing.T) {
+	c := NewBoundedCache(4, WithLockFreeReads[int]())
+	c.Add("a", 1)
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v; want 1, true", v, ok)
+	}
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) from the snapshot = %v, %v; want 1, true", v, ok)
+	}
+	c.Add("a", 2)
+	if v, _, _ := c.Get("a"); v != 2 {
+		t.Fatalf("Get(a) after overwrite = %v, want 2", v)
+	}
+	c.Remove("a")
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("Get(a) hit after Remove")
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i % 8)
+				if g%2 == 0 {
+					c.Add(key, i)
+				} else {
+					c.Get(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// BenchmarkGetHitParallel compares fresh hits under the read lock with hits
+// served from the lock-free snapshot, with 64 goroutines reading.
+func BenchmarkGetHitParallel(b *testing.B) {
+	run := func(b *testing.B, c *BoundedCache[int]) {
+		keys := make([]string, c.MaxItems()/2)
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+			c.Add(keys[i], i)
+		}
+		b.SetParallelism(max(1, 64/runtime.GOMAXPROCS(0)))
+		b.ReportAllocs()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				c.Get(keys[i%len(keys)])
+			}
+		})
+	}
+	b.Run("rwmutex", func(b *testing.B) { run(b, NewBoundedCache[int](1000)) })
+	b.Run("lockfree", func(b *testing.B) { run(b, NewBoundedCache(1000, WithLockFreeReads[int]())) })
+}
+
+func TestMarshalJSON(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+	got, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"fresh":{"c":3},"stale":{"a":1,"b":2},"max":4}`; string(got) != want {
+		t.Fatalf("MarshalJSON = %s, want %s", got, want)
+	}
+
+	funcs := NewBoundedCache[func()](2)
+	funcs.Add("f", func() {})
+	if _, err := json.Marshal(funcs); err == nil {
+		t.Fatal("MarshalJSON of unmarshalable values succeeded")
+	}
+}
+
+func TestChangeDetector(t *testing.T) {
+	replaced := 0
+	c := NewBoundedCache(4,
+		WithChangeDetector(func(old, new []int) bool { return !slices.Equal(old, new) }),
+		WithOnEvict(func(string, []int, EvictReason) { replaced++ }),
+	)
+	c.Add("a", []int{1})
+	c.Add("b", []int{2})
+	c.Add("c", []int{3}) // a and b become stale
+	_, version, _ := c.GetVersioned("a")
+
+	c.Add("a", []int{1}) // unchanged: stays stale, no new version
+	if _, ok, stale := c.Peek("a"); !ok || !stale {
+		t.Fatalf("no-op write moved a: ok %v, stale %v", ok, stale)
+	}
+	if _, v, _ := c.GetVersioned("a"); v != version {
+		t.Fatalf("no-op write changed the version from %d to %d", version, v)
+	}
+	if replaced != 0 {
+		t.Fatalf("OnEvict fired %d times for a no-op write", replaced)
+	}
+
+	c.Add("a", []int{9}) // a real change is a normal write
+	if v, _, stale := c.Peek("a"); stale || v[0] != 9 {
+		t.Fatalf("Peek(a) = %v, stale %v; want [9] in fresh", v, stale)
+	}
+}
+
+func TestStats(t *testing.T) {
+	c := NewBoundedCache[int](4) // per-generation cap 2
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+
+	c.Get("a")                                    // stale hit
+	c.Get("c")                                    // fresh hit
+	c.Get("missing")                              // miss
+	c.GetOrSet("c", 0)                            // fresh hit
+	c.GetOrCreate("new", func() int { return 4 }) // miss
+	c.Peek("b")                                   // not counted
+
+	want := Stats{FreshHits: 2, StaleHits: 1, Misses: 2}
+	if got := c.Stats(); got != want {
+		t.Fatalf("Stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsIgnoreInternalLookups(t *testing.T) {
+	n := NewNumericCache[int](4)
+	for _, key := range []string{"a", "b", "c", "a", "b", "d"} {
+		n.Increment(key, 1)
+	}
+	sc := NewSliceCache[int](4)
+	sc.Append("a", 1)
+	sc.Append("a", 2)
+	c := NewBoundedCache[int](4)
+	c.Add("a", 1)
+	if _, err := c.WaitFor(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	c.GetOrCreateWait("b", func() int { return 2 }, time.Minute) // a miss
+	c.storeCreated("a", 3)
+
+	if got := n.Stats(); got != (Stats{}) {
+		t.Errorf("Stats after Increment = %+v, want none counted", got)
+	}
+	if got := sc.Stats(); got != (Stats{}) {
+		t.Errorf("Stats after Append = %+v, want none counted", got)
+	}
+	if got, want := c.Stats(), (Stats{Misses: 1}); got != want {
+		t.Errorf("Stats after WaitFor and creates = %+v, want %+v", got, want)
+	}
+}
+
+func TestSoftTTL(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(10, WithClock[int](clock.Now), WithSoftTTL[int](time.Minute, time.Hour))
+	c.Add("a", 1)
+
+	if e, ok, _ := c.GetEntry("a"); !ok || e.Value != 1 || e.NeedsRefresh {
+		t.Fatalf("fresh GetEntry(a) = %+v, %v; want 1 without refresh", e, ok)
+	}
+	clock.Advance(2 * time.Minute)
+	if e, ok, _ := c.GetEntry("a"); !ok || !e.NeedsRefresh {
+		t.Fatalf("GetEntry(a) past soft TTL = %+v, %v; want NeedsRefresh", e, ok)
+	}
+	if _, ok, _ := c.Get("a"); !ok {
+		t.Fatal("Get(a) missed between soft and hard TTL")
+	}
+	clock.Advance(time.Hour)
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("Get(a) hit past hard TTL")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("Len = %d after the expired entry was looked up, want 0", c.Len())
+	}
+}
+
+// sliceStore is a store backed by a slice, standing in for a non-map
+// backend.
+type sliceStore[V any] struct {
+	entries []entryAt[V]
+}
+
+func (s *sliceStore[V]) find(key string) int {
+	return slices.IndexFunc(s.entries, func(ea entryAt[V]) bool { return ea.key == key })
+}
+
+func (s *sliceStore[V]) get(key string) (entry[V], bool) {
+	if i := s.find(key); i >= 0 {
+		return s.entries[i].e, true
+	}
+	return entry[V]{}, false
+}
+
+func (s *sliceStore[V]) set(key string, e entry[V]) {
+	if i := s.find(key); i >= 0 {
+		s.entries[i].e = e
+		return
+	}
+	s.entries = append(s.entries, entryAt[V]{key, e})
+}
+
+func (s *sliceStore[V]) delete(key string) {
+	if i := s.find(key); i >= 0 {
+		s.entries = slices.Delete(s.entries, i, i+1)
+	}
+}
+
+func (s *sliceStore[V]) len() int { return len(s.entries) }
+func (s *sliceStore[V]) clear()   { s.entries = nil }
+
+func (s *sliceStore[V]) all() iter.Seq2[string, entry[V]] {
+	return func(yield func(string, entry[V]) bool) {
+		for _, ea := range slices.Clone(s.entries) {
+			if !yield(ea.key, ea.e) {
+				return
+			}
+		}
+	}
+}
+
+func TestCustomStore(t *testing.T) {
+	c := NewBoundedCache(4, func(c *BoundedCache[int]) {
+		c.storeFactory = func(int) store[int] { return &sliceStore[int]{} }
+	})
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+	if _, ok, stale := c.Peek("a"); !ok || !stale {
+		t.Fatalf("Peek(a) = ok %v, stale %v; want ok, stale", ok, stale)
+	}
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v; want 1, true", v, ok)
+	}
+	c.Add("d", 4) // shift: b is discarded
+	if _, ok, _ := c.Peek("b"); ok {
+		t.Fatal("b survived the shift")
+	}
+	if got := c.Len(); got != 3 {
+		t.Fatalf("Len = %d, want 3", got)
+	}
+	c.Remove("c")
+	if got := slices.Sorted(slices.Values(c.Keys())); !slices.Equal(got, []string{"a", "d"}) {
+		t.Fatalf("Keys = %v, want [a d]", got)
+	}
+}
+
+// countingStore is a Store backed by a map that counts the stores created
+// and the entries written.
+type countingStore[V any] struct {
+	m    map[string]StoredEntry[V]
+	sets *int
+}
+
+func (s countingStore[V]) Get(key string) (StoredEntry[V], bool) {
+	se, ok := s.m[key]
+	return se, ok
+}
+
+func (s countingStore[V]) Set(key string, se StoredEntry[V]) { s.m[key] = se; *s.sets++ }
+func (s countingStore[V]) Delete(key string)                 { delete(s.m, key) }
+func (s countingStore[V]) Len() int                          { return len(s.m) }
+func (s countingStore[V]) Clear()                            { clear(s.m) }
+
+func (s countingStore[V]) All() iter.Seq2[string, StoredEntry[V]] {
+	return maps.All(s.m)
+}
+
+func TestWithStore(t *testing.T) {
+	stores, sets := 0, 0
+	c := NewBoundedCache(4, WithStore(func(capacity int) Store[int] {
+		stores++
+		return countingStore[int]{m: make(map[string]StoredEntry[int], capacity), sets: &sets}
+	}))
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v; want 1, true", v, ok)
+	}
+	c.Add("d", 4) // shift: b is discarded
+	if _, ok, _ := c.Peek("b"); ok {
+		t.Fatal("b survived the shift")
+	}
+	if got := slices.Sorted(slices.Values(c.Keys())); !slices.Equal(got, []string{"a", "c", "d"}) {
+		t.Fatalf("Keys = %v, want [a c d]", got)
+	}
+	if stores < 3 || sets < 5 {
+		t.Fatalf("the cache created %d stores and wrote %d entries; want its entries in the custom store", stores, sets)
+	}
+}
+
+func TestChurnCount(t *testing.T) {
+	c := NewBoundedCache(2, WithChurnTracking[int]()) // per-generation cap 1
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a is evicted
+	c.Add("a", 1) // a returns: churn
+	c.Add("d", 4) // c is evicted
+	c.Remove("a")
+	c.Add("a", 1) // removed, not evicted: no churn
+	if got := c.ChurnCount(); got != 1 {
+		t.Fatalf("ChurnCount = %d, want 1", got)
+	}
+
+	plain := NewBoundedCache[int](2)
+	for _, k := range []string{"a", "b", "c", "a"} {
+		plain.Add(k, 0)
+	}
+	if got := plain.ChurnCount(); got != 0 {
+		t.Fatalf("ChurnCount without tracking = %d, want 0", got)
+	}
+}
+
+func TestRemoveMany(t *testing.T) {
+	var removed []string
+	c := NewBoundedCache(10, WithOnEvict(func(key string, _ int, reason EvictReason) {
+		if reason == ReasonRemove {
+			removed = append(removed, key)
+		}
+	}))
+	for i, k := range []string{"a", "b", "c"} {
+		c.Add(k, i)
+	}
+	if n := c.RemoveMany([]string{"a", "c", "missing"}); n != 2 {
+		t.Fatalf("RemoveMany = %d, want 2", n)
+	}
+	if !slices.Equal(removed, []string{"a", "c"}) {
+		t.Fatalf("OnEvict saw %v, want [a c]", removed)
+	}
+	if got := c.Keys(); !slices.Equal(got, []string{"b"}) {
+		t.Fatalf("Keys = %v, want [b]", got)
+	}
+}
+
+func TestMemoryPressureHook(t *testing.T) {
+	pressure := false
+	c := NewBoundedCache(10, WithMemoryPressureHook[int](func() bool { return pressure }))
+	for i := 0; i < 8; i++ {
+		c.Add(strconv.Itoa(i), i)
+	}
+	if n := c.Len(); n != 8 {
+		t.Fatalf("Len without pressure = %d, want 8", n)
+	}
+
+	pressure = true
+	c.Add("x", 0) // drops the stale generation
+	c.Add("y", 0) // and again
+	if fresh, stale, _ := c.Occupancy(); fresh != 1 || stale != 1 {
+		t.Fatalf("Occupancy under pressure = %d fresh, %d stale; want 1, 1", fresh, stale)
+	}
+}
+
+func TestHitRatio(t *testing.T) {
+	c := NewBoundedCache[int](10)
+	if r := c.HitRatio(); r != 0 {
+		t.Fatalf("HitRatio before any lookup = %v, want 0", r)
+	}
+	c.Add("a", 1)
+	c.Get("a")
+	c.Get("a")
+	c.Get("a")
+	c.Get("missing")
+	if r := c.HitRatio(); r != 0.75 {
+		t.Fatalf("HitRatio = %v, want 0.75", r)
+	}
+	if r := (Stats{}).HitRatio(); r != 0 {
+		t.Fatalf("zero Stats HitRatio = %v, want 0", r)
+	}
+}
+
+func TestDeferredPromotion(t *testing.T) {
+	c := NewBoundedCache(4, WithDeferredPromotion[int]()) // per-generation cap 2
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3)
+	c.Add("d", 4) // fresh is full: c, d; stale: a, b
+
+	if v, ok, evicted := c.Get("a"); !ok || v != 1 || evicted {
+		t.Fatalf("first Get(a) = %v, %v, %v; want 1 without a shift", v, ok, evicted)
+	}
+	if _, _, stale := c.Peek("a"); !stale {
+		t.Fatal("first stale hit on a full cache promoted a")
+	}
+	if _, ok, _ := c.Get("a"); !ok {
+		t.Fatal("second Get(a) missed")
+	}
+	if _, _, stale := c.Peek("a"); stale {
+		t.Fatal("second stale hit did not promote a")
+	}
+}
+
+// BenchmarkEvictionHeavy reads a key space twice the cache's size with a
+// skew towards low keys, so that most lookups hit stale entries on a full
+// cache.
+func BenchmarkEvictionHeavy(b *testing.B) {
+	run := func(b *testing.B, opts ...Option[int]) {
+		c := NewBoundedCache(1000, opts...)
+		keys := make([]string, 2000)
+		for i := range keys {
+			keys[i] = strconv.Itoa(i)
+		}
+		create := func(key string) int { return len(key) }
+		var x uint32 = 1
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			x = x*1664525 + 1013904223
+			r := int(x>>8) % len(keys)
+			c.GetOrCreateKey(keys[r*r/len(keys)], create)
+		}
+		b.ReportMetric(c.HitRatio(), "hit-ratio")
+	}
+	b.Run("default", func(b *testing.B) { run(b) })
+	b.Run("deferred", func(b *testing.B) { run(b, WithDeferredPromotion[int]()) })
+}
+
+func TestExportOlderThan(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(10, WithClock[int](clock.Now), WithSoftTTL[int](time.Hour, 24*time.Hour))
+	c.Add("old", 1)
+	clock.Advance(10 * time.Minute)
+	c.Add("new", 2)
+	clock.Advance(time.Minute)
+
+	got := c.ExportOlderThan(5 * time.Minute)
+	if want := map[string]int{"old": 1}; !maps.Equal(got, want) {
+		t.Fatalf("ExportOlderThan = %v, want %v", got, want)
+	}
+	if c.Len() != 2 {
+		t.Fatal("ExportOlderThan removed entries")
+	}
+	if got := NewBoundedCache[int](10).ExportOlderThan(0); got != nil {
+		t.Fatalf("ExportOlderThan without timestamps = %v, want nil", got)
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	c := NewBoundedCache[int](10)
+	c.Add("ready", 1)
+	if v, err := c.WaitFor(context.Background(), "ready"); err != nil || v != 1 {
+		t.Fatalf("WaitFor(ready) = %v, %v; want 1, nil", v, err)
+	}
+
+	results := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			v, err := c.WaitFor(context.Background(), "later")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- v
+		}()
+	}
+	for { // let the waiters register
+		c.lock.Lock()
+		w := c.waiters["later"]
+		waiting := w != nil && w.n == 3
+		c.lock.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Add("later", 7)
+	for i := 0; i < 3; i++ {
+		if v := <-results; v != 7 {
+			t.Fatalf("waiter got %v, want 7", v)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.WaitFor(ctx, "never"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitFor(never) error = %v, want DeadlineExceeded", err)
+	}
+	if len(c.waiters) != 0 {
+		t.Fatalf("%d waiter records left after all waiters finished", len(c.waiters))
+	}
+}
+
+func TestWithReadLock(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+
+	var fresh, stale map[string]int
+	c.WithReadLock(func(f, s iter.Seq2[string, int]) {
+		fresh, stale = maps.Collect(f), maps.Collect(s)
+	})
+	if want := map[string]int{"c": 3}; !maps.Equal(fresh, want) {
+		t.Fatalf("fresh = %v, want %v", fresh, want)
+	}
+	if want := map[string]int{"a": 1, "b": 2}; !maps.Equal(stale, want) {
+		t.Fatalf("stale = %v, want %v", stale, want)
+	}
+}
+
+func TestOnExpire(t *testing.T) {
+	clock := newFakeClock()
+	var expired []string
+	var reasons []EvictReason
+	c := NewBoundedCache(4,
+		WithIdleTimeout[int](time.Minute),
+		WithClock[int](clock.Now),
+		WithOnExpire(func(key string, _ int) { expired = append(expired, key) }),
+		WithOnEvict(func(_ string, _ int, reason EvictReason) { reasons = append(reasons, reason) }),
+	)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Remove("b")
+
+	clock.Advance(2 * time.Minute)
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("Get(a) hit after the idle timeout")
+	}
+	if !slices.Equal(expired, []string{"a"}) {
+		t.Fatalf("expired = %v, want [a]", expired)
+	}
+	if want := []EvictReason{ReasonRemove, ReasonExpired}; !slices.Equal(reasons, want) {
+		t.Fatalf("reasons = %v, want %v", reasons, want)
+	}
+}
+
+func TestFlushStale(t *testing.T) {
+	var evicted []string
+	c := NewBoundedCache(4, WithOnEvict(func(key string, _ int, _ EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+
+	if got, want := c.FlushStale(), map[string]int{"a": 1, "b": 2}; !maps.Equal(got, want) {
+		t.Fatalf("FlushStale() = %v, want %v", got, want)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok, _ := c.Peek(key); ok {
+			t.Fatalf("Peek(%s) hit after FlushStale", key)
+		}
+	}
+	if v, ok, _ := c.Peek("c"); !ok || v != 3 {
+		t.Fatalf("Peek(c) = %d, %v; want 3, true", v, ok)
+	}
+	if len(evicted) != 0 {
+		t.Fatalf("OnEvict fired for %v during FlushStale", evicted)
+	}
+	if got := c.FlushStale(); len(got) != 0 {
+		t.Fatalf("second FlushStale() = %v, want empty", got)
+	}
+}
+
+func TestMinFreshBeforeShift(t *testing.T) {
+	c := NewBoundedCache(4, WithMinFreshBeforeShift[int](4))
+	for i := range 4 {
+		if evicted := c.Add(strconv.Itoa(i), i); evicted {
+			t.Fatalf("Add(%d) evicted before fresh reached the floor", i)
+		}
+	}
+	if fresh, stale, _ := c.Occupancy(); fresh != 4 || stale != 0 {
+		t.Fatalf("Occupancy() = %d, %d; want 4, 0", fresh, stale)
+	}
+
+	// The fifth insert shifts the four entries into the stale generation;
+	// the cache transiently holds more than MaxItems.
+	c.Add("4", 4)
+	if fresh, stale, _ := c.Occupancy(); fresh != 1 || stale != 4 {
+		t.Fatalf("Occupancy() after shift = %d, %d; want 1, 4", fresh, stale)
+	}
+	if _, ok, _ := c.Peek("0"); !ok {
+		t.Fatal("Peek(0) missed after the first shift")
+	}
+}
+
+func TestGetDetailed(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	for i, key := range []string{"a", "b", "c", "d"} {
+		c.Add(key, i) // a and b become stale
+	}
+
+	tests := []struct {
+		key                   string
+		ok, promoted, evicted bool
+	}{
+		{"c", true, false, false},  // fresh hit
+		{"a", true, true, true},    // promotion shifts b out
+		{"a", true, false, false},  // now a fresh hit
+		{"x", false, false, false}, // miss
+	}
+	for _, tt := range tests {
+		_, ok, promoted, evicted := c.GetDetailed(tt.key)
+		if ok != tt.ok || promoted != tt.promoted || evicted != tt.evicted {
+			t.Errorf("GetDetailed(%s) = %v, %v, %v; want %v, %v, %v",
+				tt.key, ok, promoted, evicted, tt.ok, tt.promoted, tt.evicted)
+		}
+	}
+}
+
+func TestSetWithMeta(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	c.SetWithMeta("a", 1, map[string]any{"source": "db"})
+	c.Add("b", 2)
+	c.Add("c", 3) // a becomes stale
+
+	if meta, ok := c.GetMeta("a"); !ok || meta["source"] != "db" {
+		t.Fatalf("GetMeta(a) in stale = %v, %v; want source db", meta, ok)
+	}
+	c.Get("a") // promote
+	if _, _, stale := c.Peek("a"); stale {
+		t.Fatal("a still stale after Get")
+	}
+	if meta, ok := c.GetMeta("a"); !ok || meta["source"] != "db" {
+		t.Fatalf("GetMeta(a) after promotion = %v, %v; want source db", meta, ok)
+	}
+	if meta, ok := c.GetMeta("b"); !ok || meta != nil {
+		t.Fatalf("GetMeta(b) = %v, %v; want nil, true", meta, ok)
+	}
+
+	c.Add("a", 10)
+	if meta, _ := c.GetMeta("a"); meta != nil {
+		t.Fatalf("GetMeta(a) after Add = %v, want nil", meta)
+	}
+	if _, ok := c.GetMeta("x"); ok {
+		t.Fatal("GetMeta(x) found an absent key")
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	var recovered []any
+	c := NewBoundedCache(4,
+		WithOnEvict(func(key string, _ int, _ EvictReason) { panic("evict " + key) }),
+		WithPanicHandler[int](func(r any) { recovered = append(recovered, r) }),
+	)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3)
+	if !c.Remove("a") {
+		t.Fatal("Remove(a) = false")
+	}
+	for i := range 4 {
+		c.Add(strconv.Itoa(i), i) // shifts out b, c and 0
+	}
+	if len(recovered) != 4 || recovered[0] != "evict a" {
+		t.Fatalf("recovered = %v, want evict a and three shift evictions", recovered)
+	}
+	if v, ok, _ := c.Get("3"); !ok || v != 3 {
+		t.Fatalf("Get(3) = %d, %v; want 3, true", v, ok)
+	}
+	if c.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", c.Len())
+	}
+}
+
+func TestPanicWithoutHandlerReleasesLock(t *testing.T) {
+	c := NewBoundedCache(4, WithHealthCheck(func(v int) bool {
+		if v < 0 {
+			panic("negative")
+		}
+		return true
+	}))
+	c.Add("bad", -1)
+	func() {
+		defer func() {
+			if r := recover(); r != "negative" {
+				t.Fatalf("recovered %v, want the health check's panic", r)
+			}
+		}()
+		c.Get("bad")
+	}()
+
+	// The lock was released, so writers still get through.
+	c.Add("good", 1)
+	if v, ok, _ := c.Get("good"); !ok || v != 1 {
+		t.Fatalf("Get(good) = %d, %v; want 1, true", v, ok)
+	}
+}
+
+func TestMostRecentlyUsed(t *testing.T) {
+	c := NewBoundedCache(10, WithAccessTracking[int]())
+	for i, key := range []string{"a", "b", "c", "d"} {
+		c.Add(key, i)
+	}
+	c.Get("b")
+	c.Get("a")
+	c.Peek("c") // Peek is not an access
+
+	if got, want := c.MostRecentlyUsed(3), []string{"a", "b", "d"}; !slices.Equal(got, want) {
+		t.Fatalf("MostRecentlyUsed(3) = %v, want %v", got, want)
+	}
+	if got := c.MostRecentlyUsed(10); len(got) != 4 {
+		t.Fatalf("MostRecentlyUsed(10) = %v, want all 4 keys", got)
+	}
+	if got := NewBoundedCache[int](10).MostRecentlyUsed(3); got != nil {
+		t.Fatalf("MostRecentlyUsed without tracking = %v, want nil", got)
+	}
+}
+
+func TestEvictionRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(4, WithClock[int](clock.Now), WithEvictionRateLimit[int](2))
+	add := func(key string) bool {
+		t.Helper()
+		evicted := c.Add(key, 0)
+		if c.Len() > 2*c.MaxItems() {
+			t.Fatalf("Len = %d after Add(%s), above twice MaxItems", c.Len(), key)
+		}
+		return evicted
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		add(key)
+	}
+	if !add("e") { // spends the whole budget discarding a and b
+		t.Fatal("Add(e) did not shift with budget available")
+	}
+	add("f")
+	if add("g") {
+		t.Fatal("Add(g) shifted with the budget exhausted")
+	}
+	if c.Len() != 5 {
+		t.Fatalf("Len = %d while the shift is deferred, want 5", c.Len())
+	}
+
+	clock.Advance(time.Second)
+	if !add("h") {
+		t.Fatal("Add(h) did not shift after the budget refilled")
+	}
+
+	// A burst without time passing defers until the overshoot bound forces
+	// the shift.
+	var shifted int
+	for i := range 4 {
+		if add(strconv.Itoa(i)) {
+			shifted++
+		}
+	}
+	if shifted != 1 {
+		t.Fatalf("%d shifts during the burst, want 1 forced by the bound", shifted)
+	}
+}
+
+func TestCopyOnPromote(t *testing.T) {
+	var copies int
+	c := NewBoundedCache(4, WithCopyOnPromote(func(v []int) []int {
+		copies++
+		return slices.Clone(v)
+	}))
+	stale := []int{1}
+	c.Add("a", stale)
+	c.Add("b", nil)
+	c.Add("c", nil) // a becomes stale
+
+	c.Get("c")
+	if copies != 0 {
+		t.Fatalf("fresh hit made %d copies", copies)
+	}
+	got, _, _ := c.Get("a")
+	if copies != 1 {
+		t.Fatalf("promotion made %d copies, want 1", copies)
+	}
+	got[0] = 2
+	if stale[0] != 1 {
+		t.Fatal("promoted value shares memory with the stale one")
+	}
+	if v, _, _ := c.Peek("a"); v[0] != 2 {
+		t.Fatalf("Peek(a) = %v, want the promoted copy", v)
+	}
+}
+
+func TestSlowCreateHook(t *testing.T) {
+	clock := newFakeClock()
+	var slow []string
+	c := NewBoundedCache(10,
+		WithClock[int](clock.Now),
+		WithSlowCreateHook[int](time.Second, func(key string, start time.Time, took time.Duration) {
+			slow = append(slow, key)
+			if took != 2*time.Second {
+				t.Errorf("took = %v for %s, want 2s", took, key)
+			}
+		}),
+	)
+	c.GetOrCreate("fast", func() int { return 1 })
+	c.GetOrCreate("slow", func() int {
+		clock.Advance(2 * time.Second)
+		return 2
+	})
+	c.GetOrCreate("slow", func() int { // a hit creates nothing
+		clock.Advance(2 * time.Second)
+		return 2
+	})
+	if !slices.Equal(slow, []string{"slow"}) {
+		t.Fatalf("slow creations = %v, want [slow]", slow)
+	}
+}
+
+func TestKeysPage(t *testing.T) {
+	c := NewBoundedCache[int](20)
+	for _, key := range []string{"d", "", "b", "e", "a"} {
+		c.Add(key, 0)
+	}
+
+	var pages [][]string
+	cursor := ""
+	for {
+		keys, next := c.KeysPage(cursor, 2)
+		pages = append(pages, keys)
+		if len(pages) == 1 {
+			c.Add("c", 0) // lands ahead of the cursor
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	want := [][]string{{"", "a"}, {"b", "c"}, {"d", "e"}}
+	if !slices.EqualFunc(pages, want, slices.Equal) {
+		t.Fatalf("pages = %q, want %q", pages, want)
+	}
+}
+
+func TestSingleGeneration(t *testing.T) {
+	var evicted []string
+	c := NewBoundedCache(3, WithSingleGeneration[int](), WithOnEvict(func(key string, _ int, _ EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	if c.MaxItems() != 3 {
+		t.Fatalf("MaxItems() = %d, want 3", c.MaxItems())
+	}
+	for i, key := range []string{"a", "b", "c"} {
+		if c.Add(key, i) {
+			t.Fatalf("Add(%s) evicted before the cache was full", key)
+		}
+	}
+	if _, _, stale := c.Peek("a"); stale {
+		t.Fatal("Peek(a) reported a stale entry")
+	}
+
+	if !c.Add("d", 3) {
+		t.Fatal("Add(d) to a full cache did not evict")
+	}
+	slices.Sort(evicted)
+	if !slices.Equal(evicted, []string{"a", "b", "c"}) {
+		t.Fatalf("evicted = %v, want [a b c]", evicted)
+	}
+	if fresh, stale, _ := c.Occupancy(); fresh != 1 || stale != 0 {
+		t.Fatalf("Occupancy() = %d, %d; want 1, 0", fresh, stale)
+	}
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("Get(a) hit after the generation was discarded")
+	}
+}
+
+func TestMaxConcurrentCreates(t *testing.T) {
+	c := NewBoundedCache(10, WithMaxConcurrentCreates[int](1))
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.GetOrCreate("slow", func() int {
+			close(started)
+			<-release
+			return 1
+		})
+	}()
+	<-started
+
+	if _, _, _, err := c.TryGetOrCreate("other", func() int { return 2 }); !errors.Is(err, ErrCreateBusy) {
+		t.Fatalf("TryGetOrCreate with the slot taken: err = %v, want ErrCreateBusy", err)
+	}
+	blocked := make(chan int)
+	go func() {
+		v, _, _ := c.GetOrCreate("other", func() int { return 3 })
+		blocked <- v
+	}()
+	select {
+	case v := <-blocked:
+		t.Fatalf("GetOrCreate created %d while the slot was taken", v)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	<-done
+	if v := <-blocked; v != 3 {
+		t.Fatalf("blocked GetOrCreate = %d, want 3", v)
+	}
+	if hits := c.CreateLimitHits(); hits != 2 {
+		t.Fatalf("CreateLimitHits() = %d, want 2", hits)
+	}
+	if v, found, _, err := c.TryGetOrCreate("other", nil); err != nil || !found || v != 3 {
+		t.Fatalf("TryGetOrCreate(other) = %d, %v, %v; want 3, true, nil", v, found, err)
+	}
+
+	// Streamed creations share the limit.
+	bc := NewByteCache(10, WithMaxConcurrentCreates[[]byte](1))
+	started, release = make(chan struct{}), make(chan struct{})
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		bc.GetOrCreateStream("slow", func(w io.Writer) error {
+			close(started)
+			<-release
+			_, err := io.WriteString(w, "1")
+			return err
+		})
+	}()
+	<-started
+	streamed := make(chan string)
+	go func() {
+		b, _ := bc.GetOrCreateStream("other", func(w io.Writer) error {
+			_, err := io.WriteString(w, "2")
+			return err
+		})
+		streamed <- string(b)
+	}()
+	select {
+	case b := <-streamed:
+		t.Fatalf("GetOrCreateStream produced %q while the slot was taken", b)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	if b := <-streamed; b != "2" {
+		t.Fatalf("blocked GetOrCreateStream = %q, want 2", b)
+	}
+	if hits := bc.CreateLimitHits(); hits != 1 {
+		t.Fatalf("ByteCache CreateLimitHits() = %d, want 1", hits)
+	}
+}
+
+func TestCaseInsensitiveKeys(t *testing.T) {
+	var evicted []string
+	c := NewBoundedCache(10, WithCaseInsensitiveKeys[int](), WithOnEvict(func(key string, _ int, _ EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	c.Add("User-1", 1)
+	if v, ok, _ := c.Get("USER-1"); !ok || v != 1 {
+		t.Fatalf("Get(USER-1) = %d, %v; want 1, true", v, ok)
+	}
+	if v, found, _ := c.GetOrCreate("user-1", func() int { return 2 }); !found || v != 1 {
+		t.Fatalf("GetOrCreate(user-1) = %d, %v; want the cached 1", v, found)
+	}
+	if _, ok, _ := c.Peek("uSeR-1"); !ok {
+		t.Fatal("Peek(uSeR-1) missed")
+	}
+	if keys := c.Keys(); !slices.Equal(keys, []string{"user-1"}) {
+		t.Fatalf("Keys() = %v, want [user-1]", keys)
+	}
+	if !c.Remove("USER-1") || c.Len() != 0 {
+		t.Fatalf("Remove(USER-1) left Len = %d", c.Len())
+	}
+	if !slices.Equal(evicted, []string{"user-1"}) {
+		t.Fatalf("OnEvict keys = %v, want [user-1]", evicted)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := NewBoundedCache[int](10)
+	a.Add("x", 1)
+	a.Add("y", 2)
+	b := NewBoundedCache[int](10)
+	b.Add("y", 20)
+	b.Add("z", 30)
+
+	a.Merge(b, func(mine, theirs int) int { return mine + theirs })
+	for key, want := range map[string]int{"x": 1, "y": 22, "z": 30} {
+		if v, ok, _ := a.Peek(key); !ok || v != want {
+			t.Errorf("Peek(%s) = %d, %v; want %d, true", key, v, ok, want)
+		}
+	}
+	if b.Len() != 2 {
+		t.Fatalf("other's Len = %d after Merge, want 2", b.Len())
+	}
+
+	// Overflow evicts the receiver's own entries first.
+	small := NewBoundedCache[int](4)
+	small.Add("old", 0)
+	big := NewBoundedCache[int](10)
+	for i := range 4 {
+		big.Add(strconv.Itoa(i), i)
+	}
+	small.Merge(big, nil)
+	if _, ok, _ := small.Peek("old"); ok {
+		t.Fatal("receiver's entry survived an overflowing merge")
+	}
+	if small.Len() != 3 {
+		t.Fatalf("Len = %d after merging 4 entries into a cap of 2, want 3", small.Len())
+	}
+}
+
+func TestHitCount(t *testing.T) {
+	c := NewBoundedCache(4, WithHitCounting[int]())
+	c.Add("a", 1)
+	c.Get("a")
+	c.GetOrCreate("a", func() int { return 0 })
+	c.Add("b", 2)
+	c.Add("c", 3) // a becomes stale
+	c.Get("a")    // promotes
+
+	if hits, ok := c.HitCount("a"); !ok || hits != 3 {
+		t.Fatalf("HitCount(a) = %d, %v; want 3, true", hits, ok)
+	}
+	c.Add("a", 10)
+	if hits, _ := c.HitCount("a"); hits != 0 {
+		t.Fatalf("HitCount(a) after a new write = %d, want 0", hits)
+	}
+	if _, ok := c.HitCount("x"); ok {
+		t.Fatal("HitCount(x) found an absent key")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	c := NewBoundedCache(10,
+		WithSoftTTL[int](time.Minute, time.Hour),
+		WithOnEvict(func(string, int, EvictReason) {}),
+	)
+	for i := range 6 {
+		c.Add(strconv.Itoa(i), i)
+	}
+	got := c.Inspect()
+	want := State{
+		Config:     c.Options(),
+		FreshCap:   5,
+		FreshLen:   1,
+		StaleLen:   5,
+		HasOnEvict: true,
+	}
+	if got != want {
+		t.Fatalf("Inspect() = %+v\nwant %+v", got, want)
+	}
+	if got.MaxItems != 10 || got.HardTTL != time.Hour {
+		t.Fatalf("Inspect() config = %+v, want MaxItems 10 and HardTTL 1h", got.Config)
+	}
+}
+
+func TestSetUntil(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(10, WithClock[int](clock.Now))
+	deadline := clock.Now().Add(time.Hour)
+	c.SetUntil("early", 1, deadline)
+	clock.Advance(50 * time.Minute)
+	c.SetUntil("late", 2, deadline)
+
+	if e, ok, _ := c.GetEntry("early"); !ok || e.NeedsRefresh {
+		t.Fatalf("GetEntry(early) before the deadline = %+v, %v; want no refresh", e, ok)
+	}
+	clock.Advance(10 * time.Minute)
+	for _, key := range []string{"early", "late"} {
+		if e, ok, _ := c.GetEntry(key); !ok || !e.NeedsRefresh {
+			t.Fatalf("GetEntry(%s) at the deadline = %+v, %v; want NeedsRefresh", key, e, ok)
+		}
+	}
+	c.Add("late", 3)
+	if e, _, _ := c.GetEntry("late"); e.NeedsRefresh {
+		t.Fatal("Add did not clear the refresh time")
+	}
+}
+
+func TestPromoteMany(t *testing.T) {
+	c := NewBoundedCache[int](6)
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Add(key, i) // a, b and c become stale
+	}
+	promoted, evicted := c.PromoteMany([]string{"a", "b", "d", "x"})
+	if promoted != 2 || !evicted {
+		t.Fatalf("PromoteMany = %d, %v; want 2, true", promoted, evicted)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, ok, stale := c.Peek(key); !ok || stale {
+			t.Fatalf("Peek(%s) = %v, stale %v; want a fresh hit", key, ok, stale)
+		}
+	}
+	if _, ok, _ := c.Peek("c"); ok {
+		t.Fatal("c survived the shift PromoteMany made room with")
+	}
+	if c.Len() > c.MaxItems() {
+		t.Fatalf("Len = %d, above MaxItems %d", c.Len(), c.MaxItems())
+	}
+
+	// Promoting a whole stale generation shifts without evicting anything,
+	// since the group left the stale generation first.
+	c = NewBoundedCache[int](4)
+	for i, key := range []string{"a", "b", "c"} {
+		c.Add(key, i) // a and b become stale
+	}
+	if promoted, evicted := c.PromoteMany([]string{"a", "b"}); promoted != 2 || evicted {
+		t.Fatalf("PromoteMany(a, b) = %d, %v; want 2, false", promoted, evicted)
+	}
+	if fresh, stale, _ := c.Occupancy(); fresh != 2 || stale != 1 {
+		t.Fatalf("Occupancy() = %d, %d; want 2, 1", fresh, stale)
+	}
+}
+
+func TestPromoteManyOrderedIteration(t *testing.T) {
+	c := NewBoundedCache(6, WithOrderedIteration[int]())
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Add(key, i) // a, b and c become stale
+	}
+	if _, evicted := c.PromoteMany([]string{"a", "b"}); !evicted {
+		t.Fatal("PromoteMany(a, b) did not evict c")
+	}
+	c.Add("c", 9)
+	if got, want := c.Keys(), []string{"a", "b", "d", "e", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("Keys() = %v, want %v", got, want)
+	}
+}
+
+func TestPromoteManyEvictionRateLimit(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(6,
+		WithEvictionRateLimit[int](1),
+		WithClock[int](clock.Now),
+	)
+	for i, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		c.Add(key, i) // discarding a, b and c leaves the budget in debt
+	}
+	promoted, evicted := c.PromoteMany([]string{"d", "e"})
+	if promoted != 1 || evicted {
+		t.Fatalf("PromoteMany = %d, %v; want 1, false with the shift deferred", promoted, evicted)
+	}
+	if _, ok, stale := c.Peek("f"); !ok || !stale {
+		t.Fatalf("Peek(f) = %v, stale %v; want it kept stale by the deferred shift", ok, stale)
+	}
+	if _, ok, stale := c.Peek("e"); !ok || !stale {
+		t.Fatalf("Peek(e) = %v, stale %v; want a stale hit", ok, stale)
+	}
+}
+
+func TestShrinkToFit(t *testing.T) {
+	heapAlloc := func() uint64 {
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+	c := NewBoundedCache[int](200_000)
+	for i := range 100_000 {
+		c.Add(strconv.Itoa(i), i)
+	}
+	for i := 10; i < 100_000; i++ {
+		c.Remove(strconv.Itoa(i))
+	}
+	before := heapAlloc()
+	c.ShrinkToFit()
+	after := heapAlloc()
+	if after >= before/2 {
+		t.Fatalf("heap after ShrinkToFit = %d bytes, want well below the %d retained before", after, before)
+	}
+	if c.Len() != 10 {
+		t.Fatalf("Len = %d after ShrinkToFit, want 10", c.Len())
+	}
+	if v, ok, _ := c.Get("7"); !ok || v != 7 {
+		t.Fatalf("Get(7) = %d, %v; want 7, true", v, ok)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	shared := NewBoundedCache[int](10)
+	shared.Add("a", 1)
+	c := NewBoundedCache(10, WithFallback[int](shared))
+
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %d, %v; want the fallback's 1", v, ok)
+	}
+	if v, _, stale := c.Peek("a"); v != 1 || stale {
+		t.Fatal("fallback hit was not stored as a fresh entry")
+	}
+	if v, found, _ := c.GetOrCreate("b", func() int { return 2 }); found || v != 2 {
+		t.Fatalf("GetOrCreate(b) = %d, %v; want a created 2", v, found)
+	}
+	if _, ok, _ := shared.Peek("b"); ok {
+		t.Fatal("created value was written back to the fallback")
+	}
+
+	fn := NewBoundedCache(10, WithFallback[int](ReaderFunc[int](func(key string) (int, bool) {
+		return len(key), key != ""
+	})))
+	if v, ok, _ := fn.Get("abc"); !ok || v != 3 {
+		t.Fatalf("Get(abc) through ReaderFunc = %d, %v; want 3, true", v, ok)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(4,
+		WithMaxLifetime[int](time.Minute),
+		WithIdleTimeout[int](time.Hour),
+		WithClock[int](clock.Now),
+	)
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a becomes stale
+	for range 5 {
+		clock.Advance(10 * time.Second)
+		if _, ok, _ := c.Get("a"); !ok {
+			t.Fatal("a expired before its lifetime")
+		}
+	}
+	c.Add("a", 10) // an overwrite keeps a's creation time
+	if v, ok, _ := c.Get("a"); !ok || v != 10 {
+		t.Fatalf("Get(a) = %v, %v; want 10, true", v, ok)
+	}
+
+	clock.Advance(10 * time.Second)
+	if _, ok, _ := c.Get("a"); ok {
+		t.Fatal("a outlived its lifetime despite hits and an overwrite")
+	}
+	if _, ok, _ := c.Get("b"); ok {
+		t.Fatal("b outlived its lifetime")
+	}
+
+	c.Add("a", 11) // a was expired, so its lifetime starts over
+	clock.Advance(30 * time.Second)
+	if v, ok, _ := c.Get("a"); !ok || v != 11 {
+		t.Fatalf("Get(a) after re-add = %v, %v; want 11, true", v, ok)
+	}
+}
+
+func TestGetOrCreateWait(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	slow := func() int {
+		calls.Add(1)
+		<-release
+		return 7
+	}
+
+	type result struct {
+		val int
+		err error
+	}
+	patient := make(chan result)
+	go func() {
+		val, _, _, err := c.GetOrCreateWait("k", slow, time.Minute)
+		patient <- result{val, err}
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, found, _, err := c.GetOrCreateWait("k", slow, 10*time.Millisecond)
+			if err != ErrCreateTimeout || val != 0 || found {
+				t.Errorf("impatient waiter got %v, %v, %v; want 0, false, ErrCreateTimeout", val, found, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(release)
+	if r := <-patient; r.err != nil || r.val != 7 {
+		t.Fatalf("patient waiter got %v, %v; want 7, nil", r.val, r.err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("create ran %d times, want 1", n)
+	}
+	if val, ok, _ := c.Get("k"); !ok || val != 7 {
+		t.Fatalf("Get(k) = %v, %v; want 7, true", val, ok)
+	}
+	if val, found, _, err := c.GetOrCreateWait("k", slow, 0); err != nil || !found || val != 7 {
+		t.Fatalf("GetOrCreateWait on a hit = %v, %v, %v; want 7, true, nil", val, found, err)
+	}
+}
+
+func TestTypedCache(t *testing.T) {
+	type point struct{ X, Y int }
+	bc := NewBoundedCache[[]byte](4)
+	tc := NewTypedCache(bc,
+		func(p point) ([]byte, error) { return json.Marshal(p) },
+		func(b []byte) (p point, err error) { return p, json.Unmarshal(b, &p) },
+	)
+
+	if err := tc.Set("p", point{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok, err := tc.Get("p"); err != nil || !ok || p != (point{1, 2}) {
+		t.Fatalf("Get(p) = %v, %v, %v; want {1 2}, true, nil", p, ok, err)
+	}
+	if raw, _, _ := bc.Get("p"); string(raw) != `{"X":1,"Y":2}` {
+		t.Fatalf("stored bytes = %s", raw)
+	}
+	if _, ok, err := tc.Get("missing"); ok || err != nil {
+		t.Fatalf("Get(missing) = %v, %v; want false, nil", ok, err)
+	}
+
+	bc.Add("bad", []byte("not json"))
+	if _, ok, err := tc.Get("bad"); ok || err == nil {
+		t.Fatalf("Get(bad) = %v, %v; want false and a decode error", ok, err)
+	}
+
+	failing := NewTypedCache(bc,
+		func(point) ([]byte, error) { return nil, errors.New("boom") },
+		func([]byte) (point, error) { return point{}, nil },
+	)
+	if err := failing.Set("q", point{}); err == nil {
+		t.Fatal("Set with a failing marshal returned nil")
+	}
+	if _, ok, _ := bc.Peek("q"); ok {
+		t.Fatal("a value that failed to encode was stored")
+	}
+}
+
+func TestStaleHitsAsMisses(t *testing.T) {
+	c := NewBoundedCache(4, WithStaleHitsAsMisses[int]())
+	c.Add("a", 1)
+	c.Add("b", 2)
+	c.Add("c", 3) // a and b become stale
+
+	if v, ok, _ := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Get(a) = %v, %v; want 1, true", v, ok)
+	}
+	c.Get("a") // now fresh
+	c.Get("x")
+	want := Stats{FreshHits: 1, Misses: 2}
+	if got := c.Stats(); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+	if r := c.HitRatio(); r != 1.0/3 {
+		t.Fatalf("HitRatio() = %v, want 1/3", r)
+	}
+	if _, ok, stale := c.Peek("a"); !ok || stale {
+		t.Fatal("the stale hit was not promoted")
+	}
+}
+
+func TestGetOrCreateWaitPanic(t *testing.T) {
+	c := NewBoundedCache[int](4)
+	release := make(chan struct{})
+	var calls atomic.Int32
+	panicky := func() int {
+		calls.Add(1)
+		<-release
+		panic("boom")
+	}
+
+	errs := make(chan error, 3)
+	for range 3 {
+		go func() {
+			_, _, _, err := c.GetOrCreateWait("k", panicky, time.Minute)
+			errs <- err
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // let the other callers join
+	close(release)
+	for range 3 {
+		var pe *PanicError
+		if err := <-errs; !errors.As(err, &pe) || pe.Value != "boom" {
+			t.Fatalf("GetOrCreateWait error = %v, want a PanicError carrying boom", err)
+		}
+	}
+	if _, ok, _ := c.Peek("k"); ok {
+		t.Fatal("a panicking creation stored a value")
+	}
+
+	if val, _, _, err := c.GetOrCreateWait("k", func() int { return 7 }, time.Minute); err != nil || val != 7 {
+		t.Fatalf("GetOrCreateWait after the panic = %v, %v; want 7, nil", val, err)
+	}
+}
+
+func TestExportOlderThanMaxLifetime(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(4, WithMaxLifetime[int](time.Hour), WithClock[int](clock.Now))
+	c.Add("old", 1)
+	clock.Advance(time.Minute)
+	c.Add("new", 2)
+	if got, want := c.ExportOlderThan(time.Minute), map[string]int{"old": 1}; !maps.Equal(got, want) {
+		t.Fatalf("ExportOlderThan = %v, want %v", got, want)
+	}
+}
+
+func TestGetOrCreateWaitUnsafe(t *testing.T) {
+	c := NewUnsafeBoundedCache[int](4)
+	c.Add("a", 1)
+	val, found, _, err := c.GetOrCreateWait("k", func() int { return 7 }, time.Minute)
+	if err != nil || found || val != 7 {
+		t.Fatalf("GetOrCreateWait = %v, %v, %v; want 7, false, nil", val, found, err)
+	}
+	c.Add("b", 2) // used right away from this goroutine, with no race
+	if v, ok, _ := c.Get("k"); !ok || v != 7 {
+		t.Fatalf("Get(k) = %v, %v; want 7, true", v, ok)
+	}
+
+	release := make(chan struct{})
+	if _, _, _, err := c.GetOrCreateWait("slow", func() int { <-release; return 8 }, time.Millisecond); err != ErrCreateTimeout {
+		t.Fatalf("GetOrCreateWait(slow) err = %v, want ErrCreateTimeout", err)
+	}
+	close(release)
+	c.Add("c", 3)
+	if _, ok, _ := c.Peek("slow"); ok {
+		t.Fatal("a timed-out creation was stored on an unsafe cache")
+	}
+}
+
+func TestSetWithMetaChangeDetector(t *testing.T) {
+	c := NewBoundedCache(4, WithChangeDetector(func(old, new int) bool { return old != new }))
+	c.SetWithMeta("k", 1, map[string]any{"source": "db"})
+	c.Add("k", 1) // unchanged, so overwritten in place
+	if meta, ok := c.GetMeta("k"); !ok || meta != nil {
+		t.Fatalf("GetMeta(k) after an unchanged Add = %v, %v; want nil, true", meta, ok)
+	}
+	c.SetWithMeta("k", 1, map[string]any{"source": "cache"})
+	if meta, _ := c.GetMeta("k"); meta["source"] != "cache" {
+		t.Fatalf("GetMeta(k) after an unchanged SetWithMeta = %v, want source cache", meta)
+	}
+}
+
+func TestSetUntilCleared(t *testing.T) {
+	clock := newFakeClock()
+	for _, detect := range []bool{false, true} {
+		opts := []Option[int]{WithClock[int](clock.Now)}
+		if detect {
+			opts = append(opts, WithChangeDetector(func(old, new int) bool { return old != new }))
+		}
+		c := NewBoundedCache(4, opts...)
+		c.SetUntil("k", 1, clock.Now().Add(time.Minute))
+		c.Add("k", 1) // a plain write, kept in place under the change detector
+		clock.Advance(time.Hour)
+		if e, ok, _ := c.GetEntry("k"); !ok || e.NeedsRefresh {
+			t.Fatalf("change detector %v: GetEntry(k) = %+v, %v; want the refresh time cleared", detect, e, ok)
+		}
+	}
+}
+
+func TestSingleGenerationEvictionStatus(t *testing.T) {
+	c := NewBoundedCache(3, WithSingleGeneration[int]())
+	c.Add("a", 1)
+	c.Add("b", 2)
+	if got := c.EvictionCandidates(); len(got) != 0 {
+		t.Fatalf("EvictionCandidates() before full = %v, want none", got)
+	}
+	if _, status := c.PeekStatus("a"); status != StatusFresh {
+		t.Fatalf("PeekStatus(a) before full = %v, want fresh", status)
+	}
+	c.Add("c", 3)
+	if got := slices.Sorted(slices.Values(c.EvictionCandidates())); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("EvictionCandidates() when full = %v, want [a b c]", got)
+	}
+	if _, status := c.PeekStatus("a"); status != StatusAboutToDrop {
+		t.Fatalf("PeekStatus(a) when full = %v, want about-to-drop", status)
+	}
+	c.Add("d", 4)
+	if _, ok, _ := c.Peek("a"); ok {
+		t.Fatal("a survived the insert it was reported about to drop on")
+	}
+}
+
+func TestEvictionRateLimitEmptyStale(t *testing.T) {
+	clock := newFakeClock()
+	c := NewBoundedCache(4, WithClock[int](clock.Now), WithEvictionRateLimit[int](1))
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		c.Add(key, 0) // discarding a and b leaves the budget in debt
+	}
+	c.Remove("c")
+	c.Remove("d")
+	c.Add("f", 0)
+	c.Add("g", 0) // the shift discards nothing, so the debt does not defer it
+	if fresh, stale, _ := c.Occupancy(); fresh != 1 || stale != 2 {
+		t.Fatalf("Occupancy() = %d, %d; want 1, 2 after the shift", fresh, stale)
+	}
+}
+
+func TestByteCacheGetOrCreateStreamCopyOnRead(t *testing.T) {
+	c := NewByteCache(4, WithCopyOnRead(slices.Clone[[]byte]))
+	got, err := c.GetOrCreateStream("k", func(w io.Writer) error {
+		c.Add("k", []byte("theirs")) // another writer wins the race
+		_, err := io.WriteString(w, "mine")
+		return err
+	})
+	if err != nil || string(got) != "theirs" {
+		t.Fatalf("GetOrCreateStream = %q, %v; want the winning theirs", got, err)
+	}
+	got[0] = 'X'
+	if v, _, _ := c.Peek("k"); string(v) != "theirs" {
+		t.Fatalf("cached value = %q after modifying the returned slice, want theirs", v)
+	}
+}
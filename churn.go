@@ -0,0 +1,39 @@
+package cache
+
+// ChurnCount returns how many times since the cache was created a key that
+// had been evicted for lack of room was stored again while still remembered
+// by WithChurnTracking. A count that keeps rising means the working set does
+// not fit and the cache is too small. Without WithChurnTracking it is always
+// zero.
+func (c *BoundedCache[V]) ChurnCount() uint64 {
+	return c.churn.Load()
+}
+
+// ghostSet remembers recently evicted keys. Like the cache itself it keeps
+// two generations of keys and discards the older one when the newer fills,
+// so it never holds more than twice the generation cap.
+type ghostSet struct {
+	fresh, stale map[string]struct{}
+}
+
+// add remembers key, shifting the generations if the fresh one already holds
+// limit keys.
+func (g *ghostSet) add(key string, limit int) {
+	if g.fresh == nil || len(g.fresh) >= limit {
+		g.stale, g.fresh = g.fresh, make(map[string]struct{}, limit)
+	}
+	g.fresh[key] = struct{}{}
+}
+
+// take reports whether key is remembered and forgets it.
+func (g *ghostSet) take(key string) bool {
+	if _, ok := g.fresh[key]; ok {
+		delete(g.fresh, key)
+		return true
+	}
+	if _, ok := g.stale[key]; ok {
+		delete(g.stale, key)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrCreateTimeout is returned by GetOrCreateWait when the value is not
+// ready within the caller's maxWait.
+var ErrCreateTimeout = errors.New("cache: timed out waiting for create")
+
+// createCall is a creation started by GetOrCreateWait that other callers for
+// the same key wait on. done is closed once the result is stored, or err
+// set if create panicked.
+type createCall[V any] struct {
+	done    chan struct{}
+	val     V
+	found   bool
+	evicted bool
+	err     error
+}
+
+// GetOrCreateWait is like GetOrCreate, but concurrent misses on key share a
+// single call of create, and no caller waits for it longer than maxWait. The
+// first caller to miss starts create on its own goroutine; it and every
+// caller that misses while create runs wait for the result, and each one
+// that is still waiting after maxWait returns ErrCreateTimeout. A caller that
+// times out gets no value, only the zero V, but the creation is not
+// abandoned: it runs to completion, is stored as GetOrCreate would store it,
+// and is returned to the callers still waiting. If create panics, the panic
+// is recovered, nothing is stored, and every caller still waiting gets the
+// zero V and a *PanicError carrying the panic value.
+//
+// As for GetOrCreate, found is false for a created value and true when
+// another goroutine stored key while create ran, for every caller that
+// waited. Only the caller that started the creation is told whether storing
+// it evicted.
+//
+// On a cache from NewUnsafeBoundedCache only create runs on the other
+// goroutine, and the caller stores its result once it returns, so the cache
+// is only ever touched from the calling goroutine. There are no other callers
+// to share the creation with, and a creation its caller timed out on is
+// discarded when it completes instead of stored.
+func (c *BoundedCache[V]) GetOrCreateWait(key string, create func() V, maxWait time.Duration) (val V, found bool, evicted bool, err error) {
+	key = c.foldKey(key)
+	if create == nil {
+		val, found, evicted = c.Get(key)
+		return val, found, evicted, nil
+	}
+	if val, ok, evicted := c.Get(key); ok {
+		return val, true, evicted, nil
+	}
+	val, call, started, evicted := c.joinCreate(key, create)
+	if call == nil {
+		return c.copyOut(val), true, evicted, nil
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-call.done:
+	case <-timer.C:
+		var zero V
+		return zero, false, false, ErrCreateTimeout
+	}
+	if call.err != nil {
+		var zero V
+		return zero, false, false, call.err
+	}
+	if !started {
+		return c.copyOut(call.val), call.found, false, nil
+	}
+	if c.unlocked {
+		call.val, call.found, call.evicted = c.storeCreated(key, call.val)
+	}
+	val = call.val
+	if call.found {
+		val = c.copyOut(val)
+	}
+	return val, call.found, call.evicted, nil
+}
+
+// joinCreate returns the creation of key in flight, or starts one running
+// create if there is none, in which case started is set. If key was stored
+// since the caller missed, it returns its value and a nil call instead.
+func (c *BoundedCache[V]) joinCreate(key string, create func() V) (val V, call *createCall[V], started bool, evicted bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if val, ok, evicted := c.getLocked(key); ok {
+		return val, nil, false, evicted
+	}
+	call = &createCall[V]{done: make(chan struct{})}
+	if !c.unlocked {
+		if joined := c.creating[key]; joined != nil {
+			return val, joined, false, false
+		}
+		if c.creating == nil {
+			c.creating = make(map[string]*createCall[V])
+		}
+		c.creating[key] = call
+	}
+	go c.runCreateCall(key, call, create)
+	return val, call, true, false
+}
+
+// runCreateCall runs create for the creation call of key, stores the result
+// and wakes the callers waiting on it. A panic in create is recovered into
+// the call's error. On an unlocked cache it leaves the result in call.val
+// for the caller to store and does not touch the cache.
+func (c *BoundedCache[V]) runCreateCall(key string, call *createCall[V], create func() V) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = &PanicError{Value: r}
+		}
+		if !c.unlocked {
+			c.lock.Lock()
+			delete(c.creating, key)
+			c.lock.Unlock()
+		}
+		close(call.done)
+	}()
+	var created V
+	c.runCreate(key, true, func() { created = create() })
+	if c.unlocked {
+		call.val = created
+		return
+	}
+	call.val, call.found, call.evicted = c.storeCreated(key, created)
+}
@@ -0,0 +1,107 @@
+package cache
+
+import "time"
+
+// EventType identifies what happened in an Event.
+type EventType int
+
+const (
+	// EventHit is a lookup that found its key.
+	EventHit EventType = iota
+	// EventMiss is a lookup that did not find its key.
+	EventMiss
+	// EventEvict is an entry discarded by a generation shift.
+	EventEvict
+	// EventShift is the fresh generation being demoted to stale.
+	EventShift
+	// EventCreate is a value created or defaulted on a miss being stored.
+	EventCreate
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventHit:
+		return "hit"
+	case EventMiss:
+		return "miss"
+	case EventEvict:
+		return "evict"
+	case EventShift:
+		return "shift"
+	case EventCreate:
+		return "create"
+	}
+	return "unknown"
+}
+
+// EvictReason tells an OnEvict hook why a value was dropped.
+type EvictReason int
+
+const (
+	// ReasonShift means the entry was discarded to make room, normally by a
+	// generation shift.
+	ReasonShift EvictReason = iota
+	// ReasonRemove means the entry was removed explicitly.
+	ReasonRemove
+	// ReasonPurge means the entry was dropped by Purge.
+	ReasonPurge
+	// ReasonReplace means the value was overwritten by a new write of its
+	// key or dropped by ReplaceAll.
+	ReasonReplace
+	// ReasonExpired means the entry outlived its idle timeout, its hard TTL
+	// or its max lifetime, whichever ran out first.
+	ReasonExpired
+	// ReasonUnhealthy means the entry failed the health check on a hit.
+	ReasonUnhealthy
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case ReasonShift:
+		return "shift"
+	case ReasonRemove:
+		return "remove"
+	case ReasonPurge:
+		return "purge"
+	case ReasonReplace:
+		return "replace"
+	case ReasonExpired:
+		return "expired"
+	case ReasonUnhealthy:
+		return "unhealthy"
+	}
+	return "unknown"
+}
+
+// Event describes a single cache event delivered to the hook installed with
+// WithEventHook. Key is empty for EventShift.
+type Event struct {
+	Type EventType
+	Key  string
+	Time time.Time
+}
+
+// WithEventHook routes every hit, miss, eviction, shift and create to hook.
+// The hook runs synchronously on the goroutine performing the operation,
+// usually with the cache's lock held, so it must be fast and must not call
+// back into the cache. Without a hook no events are built.
+func WithEventHook[V any](hook func(Event)) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.onEvent = hook
+	}
+}
+
+// emit delivers an event to the hook. Callers check that onEvent is set so
+// that the disabled path costs a single nil comparison.
+func (c *BoundedCache[V]) emit(t EventType, key string) {
+	c.onEvent(Event{Type: t, Key: key, Time: c.now()})
+}
+
+// emitLookup emits a hit or miss for key.
+func (c *BoundedCache[V]) emitLookup(key string, hit bool) {
+	if hit {
+		c.emit(EventHit, key)
+	} else {
+		c.emit(EventMiss, key)
+	}
+}
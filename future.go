@@ -0,0 +1,61 @@
+package cache
+
+import "fmt"
+
+// Future is a value that is being, or has been, computed on another
+// goroutine. It is safe for concurrent use.
+type Future[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+// PanicError is the error a Future resolves with when its computation
+// panicked, and the error GetOrCreateWait returns when create panicked.
+type PanicError struct {
+	Value any
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("cache: create panicked: %v", e.Value)
+}
+
+// Done returns a channel that is closed once the future has resolved.
+func (f *Future[V]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Await blocks until the future has resolved and returns its result. The
+// error is non-nil only if the computation panicked, in which case it is a
+// *PanicError.
+func (f *Future[V]) Await() (V, error) {
+	<-f.done
+	return f.val, f.err
+}
+
+// run resolves f with the result of create, recovering a panic into a
+// PanicError.
+func (f *Future[V]) run(create func() V) {
+	defer close(f.done)
+	defer func() {
+		if r := recover(); r != nil {
+			f.err = &PanicError{Value: r}
+		}
+	}()
+	f.val = create()
+}
+
+// GetOrCreateFuture returns the future cached under key in c. On a miss it
+// stores a new future and starts create on its own goroutine to resolve it,
+// so every caller that arrives before or after completion shares the same
+// computation and result. The resolved future stays cached, subject to
+// eviction like any other entry; a future that resolved with a panic is
+// cached too, so callers that want to retry should Remove it.
+func GetOrCreateFuture[V any](c *BoundedCache[*Future[V]], key string, create func() V) *Future[V] {
+	f := &Future[V]{done: make(chan struct{})}
+	got, _, _ := c.GetOrCreate(key, func() *Future[V] { return f })
+	if got == f {
+		go f.run(create)
+	}
+	return got
+}
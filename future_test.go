@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGetOrCreateFuture(t *testing.T) {
+	c := NewBoundedCache[*Future[int]](4)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	create := func() int {
+		calls.Add(1)
+		<-release
+		return 42
+	}
+
+	var wg sync.WaitGroup
+	futures := make([]*Future[int], 8)
+	for i := range futures {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			futures[i] = GetOrCreateFuture(c, "k", create)
+		}(i)
+	}
+	wg.Wait()
+	close(release)
+
+	for _, f := range futures {
+		if f != futures[0] {
+			t.Fatal("callers received different futures")
+		}
+		if v, err := f.Await(); err != nil || v != 42 {
+			t.Fatalf("Await() = %v, %v; want 42, nil", v, err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("create called %d times, want 1", n)
+	}
+	if v, _ := GetOrCreateFuture(c, "k", create).Await(); v != 42 {
+		t.Fatalf("resolved future not cached: got %v", v)
+	}
+}
+
+func TestGetOrCreateFuturePanic(t *testing.T) {
+	c := NewBoundedCache[*Future[int]](4)
+	f := GetOrCreateFuture(c, "k", func() int { panic("boom") })
+	_, err := f.Await()
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Fatalf("Await() error = %v, want PanicError(boom)", err)
+	}
+}
@@ -0,0 +1,123 @@
+// Package httpcache provides HTTP middleware that caches GET responses in a
+// BoundedCache.
+package httpcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	cache "github.com/ryderlewis/boundedcache"
+)
+
+// Option configures Middleware.
+type Option func(*config)
+
+type config struct {
+	statusCodes map[int]bool
+}
+
+// WithStatusCodes sets the response status codes whose responses are
+// cached. By default only 200 responses are cached.
+func WithStatusCodes(codes ...int) Option {
+	return func(cfg *config) {
+		cfg.statusCodes = make(map[int]bool, len(codes))
+		for _, code := range codes {
+			cfg.statusCodes[code] = true
+		}
+	}
+}
+
+// Middleware returns middleware that serves GET requests from c, keyed by
+// keyFn. On a miss the wrapped handler serves the request as usual while its
+// response is captured, and the response is stored if its status code is
+// cacheable. On a hit the cached status code, headers and body are replayed
+// without invoking the wrapped handler. Other methods pass straight through.
+//
+// Each entry in c holds a whole response, encoded; values stored in c by
+// other code are not served, but passed to the wrapped handler as misses.
+func Middleware(c *cache.BoundedCache[[]byte], keyFn func(*http.Request) string, opts ...Option) func(http.Handler) http.Handler {
+	cfg := config{statusCodes: map[int]bool{http.StatusOK: true}}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			served := false
+			stored, _, _ := c.GetOrCreateCond(keyFn(r), func() ([]byte, bool) {
+				served = true
+				rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+				next.ServeHTTP(rec, r)
+				if !cfg.statusCodes[rec.status] {
+					return nil, false
+				}
+				b, err := json.Marshal(response{
+					Status: rec.status,
+					Header: rec.sentHeader(),
+					Body:   rec.body.Bytes(),
+				})
+				return b, err == nil
+			})
+			if served {
+				return
+			}
+			var resp response
+			if err := json.Unmarshal(stored, &resp); err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for key, values := range resp.Header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(resp.Status)
+			w.Write(resp.Body)
+		})
+	}
+}
+
+// response is a cached response, as stored in the cache.
+type response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// recorder passes a response through to the client while capturing its
+// status code, headers and body.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header // as sent, once the header has been written
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.header == nil {
+		r.status = status
+		r.header = r.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.header == nil {
+		r.header = r.Header().Clone()
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// sentHeader returns the headers sent with the response, or the current
+// ones if the handler wrote nothing.
+func (r *recorder) sentHeader() http.Header {
+	if r.header == nil {
+		return r.Header().Clone()
+	}
+	return r.header
+}
@@ -0,0 +1,83 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cache "github.com/ryderlewis/boundedcache"
+)
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("body " + r.URL.Path))
+	})
+	c := cache.NewBoundedCache[[]byte](10)
+	h := Middleware(c, func(r *http.Request) string { return r.URL.Path })(handler)
+
+	get := func(method, path string) string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Body.String()
+	}
+
+	for i := 0; i < 2; i++ {
+		if body := get(http.MethodGet, "/a"); body != "body /a" {
+			t.Fatalf("GET /a = %q", body)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times for cached GET, want 1", calls)
+	}
+
+	get(http.MethodGet, "/missing")
+	get(http.MethodGet, "/missing")
+	if calls != 3 {
+		t.Fatalf("404 response was cached")
+	}
+
+	get(http.MethodPost, "/a")
+	if calls != 4 {
+		t.Fatalf("POST was served from cache")
+	}
+}
+
+func TestMiddlewareReplaysStatusAndHeaders(t *testing.T) {
+	calls := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Location", "/new")
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusMovedPermanently)
+		w.Write([]byte("moved"))
+	})
+	c := cache.NewBoundedCache[[]byte](10)
+	h := Middleware(c, func(r *http.Request) string { return r.URL.Path },
+		WithStatusCodes(http.StatusMovedPermanently))(handler)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/old", nil))
+		if rec.Code != http.StatusMovedPermanently {
+			t.Fatalf("GET /old #%d status = %d, want 301", i, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != "/new" {
+			t.Fatalf("GET /old #%d Location = %q, want /new", i, got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "text/plain" {
+			t.Fatalf("GET /old #%d Content-Type = %q, want text/plain", i, got)
+		}
+		if body := rec.Body.String(); body != "moved" {
+			t.Fatalf("GET /old #%d body = %q, want moved", i, body)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("handler called %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,58 @@
+package cache
+
+// State is a snapshot of a cache's resolved configuration and occupancy,
+// returned by Inspect. It is a plain value, cheap to copy and compare.
+type State struct {
+	// Config is the resolved configuration, as returned by Options,
+	// including MaxItems and the IdleTimeout, SoftTTL and HardTTL
+	// durations.
+	Config
+	// FreshCap is the number of entries the fresh generation holds before
+	// an insert shifts it.
+	FreshCap int
+	// FreshLen and StaleLen are the entries held by each generation,
+	// counting expired entries that have not been dropped yet.
+	FreshLen int
+	StaleLen int
+	// Generation is the counter advanced by Purge and ReplaceAll.
+	Generation uint64
+
+	// The Has fields report which hooks are installed.
+	HasOnEvict        bool
+	HasOnPromote      bool
+	HasOnExpire       bool
+	HasEventHook      bool
+	HasEvictionFilter bool
+	HasHealthCheck    bool
+	HasVictimCache    bool
+	HasFallback       bool
+	HasCopyOnRead     bool
+	HasCopyOnPromote  bool
+	HasPanicHandler   bool
+}
+
+// Inspect returns the cache's configuration and state in a single value,
+// read under the read lock, for tests and diagnostics that need to assert on
+// several settings at once.
+func (c *BoundedCache[V]) Inspect() State {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return State{
+		Config:            c.cfg,
+		FreshCap:          c.shiftAt(),
+		FreshLen:          c.freshItems.len(),
+		StaleLen:          c.staleItems.len(),
+		Generation:        c.generation.Load(),
+		HasOnEvict:        c.onEvict != nil,
+		HasOnPromote:      c.onPromote != nil,
+		HasOnExpire:       c.onExpire != nil,
+		HasEventHook:      c.onEvent != nil,
+		HasEvictionFilter: c.evictionFilter != nil,
+		HasHealthCheck:    c.healthCheck != nil,
+		HasVictimCache:    c.victim != nil,
+		HasFallback:       c.fallback != nil,
+		HasCopyOnRead:     c.copyOnRead != nil,
+		HasCopyOnPromote:  c.copyOnPromote != nil,
+		HasPanicHandler:   c.panicHandler != nil,
+	}
+}
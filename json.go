@@ -0,0 +1,37 @@
+package cache
+
+import "encoding/json"
+
+// MarshalJSON dumps the cache for debugging as
+//
+//	{"fresh": {key: value, ...}, "stale": {key: value, ...}, "max": MaxItems}
+//
+// without promoting anything; expired entries are left out. The entries are
+// copied under the read lock and encoded after it is released. Values are
+// encoded with encoding/json, so MarshalJSON returns an error at run time if
+// V is not marshalable. It copies the whole cache and is meant for admin
+// endpoints, not hot paths.
+func (c *BoundedCache[V]) MarshalJSON() ([]byte, error) {
+	c.lock.RLock()
+	dump := struct {
+		Fresh map[string]V `json:"fresh"`
+		Stale map[string]V `json:"stale"`
+		Max   int          `json:"max"`
+	}{
+		Fresh: make(map[string]V, c.freshItems.len()),
+		Stale: make(map[string]V, c.staleItems.len()),
+		Max:   c.maxItems(),
+	}
+	for key, e := range c.freshItems.all() {
+		if !c.expired(e) {
+			dump.Fresh[key] = e.value
+		}
+	}
+	for key, e := range c.staleItems.all() {
+		if !c.expired(e) {
+			dump.Stale[key] = e.value
+		}
+	}
+	c.lock.RUnlock()
+	return json.Marshal(dump)
+}
@@ -0,0 +1,70 @@
+package cache
+
+import "time"
+
+// SetWithMeta is like Add but stores meta alongside val, for side-band
+// information such as where the value came from or how long it took to
+// fetch. The metadata moves with the entry when it is promoted or shifted and
+// is dropped with it; any later write of key through another method, and a
+// spill into a victim cache, drops it too. Every entry carries an extra
+// pointer for its metadata whether or not it has any, and the cache keeps a
+// reference to meta, so the caller must not modify it afterwards.
+func (c *BoundedCache[V]) SetWithMeta(key string, val V, meta map[string]any) (evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.rejects(val) {
+		return false
+	}
+	evicted = c.add(key, val)
+	c.annotate(key, func(e *entry[V]) { e.meta = meta })
+	return evicted
+}
+
+// GetMeta returns the metadata stored with key by SetWithMeta, without
+// promoting the entry. ok is false if key is absent; an entry written without
+// metadata returns a nil map and true. The returned map is shared with the
+// cache and must not be modified.
+func (c *BoundedCache[V]) GetMeta(key string) (meta map[string]any, ok bool) {
+	key = c.foldKey(key)
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	e, _, ok := c.lookup(key)
+	return e.meta, ok
+}
+
+// SetUntil is like Add but marks the entry as needing a refresh from the
+// absolute time refreshAt onwards, as read from the cache's clock. Unlike
+// WithSoftTTL, which measures each entry's age from its own write, every
+// entry stored with the same refreshAt comes due at the same moment however
+// long ago it was written, which suits invalidation scheduled for a fixed
+// point such as a deploy. Past refreshAt the entry is still served, and
+// GetEntry reports it with NeedsRefresh set so the caller can refresh it;
+// only expiry options remove it. A later write of key through another method
+// clears the refresh time.
+func (c *BoundedCache[V]) SetUntil(key string, val V, refreshAt time.Time) (evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.rejects(val) {
+		return false
+	}
+	evicted = c.add(key, val)
+	c.annotate(key, func(e *entry[V]) { e.refreshAt = refreshAt.UnixNano() })
+	return evicted
+}
+
+// annotate applies set to the entry stored under key, if any, in place. The
+// caller must hold the write lock.
+func (c *BoundedCache[V]) annotate(key string, set func(e *entry[V])) {
+	e, stale, ok := c.rawLookup(key)
+	if !ok {
+		return
+	}
+	set(&e)
+	if stale {
+		c.staleItems.set(key, e)
+	} else {
+		c.freshItems.set(key, e)
+	}
+}
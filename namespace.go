@@ -0,0 +1,82 @@
+package cache
+
+import "strings"
+
+// Namespace is a view of a BoundedCache in which every key is transparently
+// prefixed, so that components sharing one cache cannot collide. Callers use
+// bare keys; the shared cache stores prefix+key. All namespaces of a cache
+// share its capacity and eviction, and hooks registered on the cache see the
+// stored, prefixed keys.
+type Namespace[V any] struct {
+	cache  *BoundedCache[V]
+	prefix string
+}
+
+var _ Cache[int] = (*Namespace[int])(nil)
+
+// WithKeyPrefix returns a Namespace that stores its keys in c under prefix.
+// Prefixes of different namespaces should not be prefixes of each other, or
+// their keys can collide.
+func (c *BoundedCache[V]) WithKeyPrefix(prefix string) *Namespace[V] {
+	return &Namespace[V]{cache: c, prefix: c.foldKey(prefix)}
+}
+
+// Add stores val under the prefixed key, like BoundedCache.Add.
+func (n *Namespace[V]) Add(key string, val V) (evicted bool) {
+	return n.cache.Add(n.prefix+key, val)
+}
+
+// Get returns the value stored under the prefixed key, like
+// BoundedCache.Get.
+func (n *Namespace[V]) Get(key string) (val V, ok bool, evicted bool) {
+	return n.cache.Get(n.prefix + key)
+}
+
+// GetOrCreate is BoundedCache.GetOrCreate for the prefixed key.
+func (n *Namespace[V]) GetOrCreate(key string, create func() V) (val V, found bool, evicted bool) {
+	return n.cache.GetOrCreate(n.prefix+key, create)
+}
+
+// Peek is BoundedCache.Peek for the prefixed key.
+func (n *Namespace[V]) Peek(key string) (val V, ok bool, stale bool) {
+	return n.cache.Peek(n.prefix + key)
+}
+
+// Remove is BoundedCache.Remove for the prefixed key.
+func (n *Namespace[V]) Remove(key string) bool {
+	return n.cache.Remove(n.prefix + key)
+}
+
+// Len returns the number of entries in the namespace. It scans the whole
+// shared cache, so unlike BoundedCache.Len it is O(n).
+func (n *Namespace[V]) Len() int {
+	count := 0
+	for range n.cache.KeysFunc(n.owns) {
+		count++
+	}
+	return count
+}
+
+// Keys returns the bare keys of the namespace's entries, in the order
+// BoundedCache.Keys would report them.
+func (n *Namespace[V]) Keys() []string {
+	var keys []string
+	for key := range n.cache.KeysFunc(n.owns) {
+		keys = append(keys, key[len(n.prefix):])
+	}
+	return keys
+}
+
+// StoredKeys is like Keys but returns the keys as stored in the shared
+// cache, prefix included.
+func (n *Namespace[V]) StoredKeys() []string {
+	var keys []string
+	for key := range n.cache.KeysFunc(n.owns) {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (n *Namespace[V]) owns(key string) bool {
+	return strings.HasPrefix(key, n.prefix)
+}
@@ -0,0 +1,32 @@
+package cache
+
+// Number is the set of types NumericCache can hold.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// NumericCache is a BoundedCache of numbers with atomic arithmetic helpers,
+// suited to counters and metric aggregation.
+type NumericCache[N Number] struct {
+	*BoundedCache[N]
+}
+
+// NewNumericCache returns a NumericCache that holds at most maxItems entries.
+func NewNumericCache[N Number](maxItems int, opts ...Option[N]) *NumericCache[N] {
+	return &NumericCache[N]{NewBoundedCache(maxItems, opts...)}
+}
+
+// Increment adds delta to the number stored under key, treating a missing key
+// as zero, stores the result in the fresh generation and returns it. The
+// read, the addition and the write happen under a single lock acquisition.
+// evicted reports whether storing the result discarded stale entries.
+func (c *NumericCache[N]) Increment(key string, delta N) (newVal N, evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	old, _, evicted := c.getLocked(key)
+	newVal = old + delta
+	return newVal, c.add(key, newVal) || evicted
+}
@@ -0,0 +1,570 @@
+package cache
+
+import "time"
+
+// Option configures a BoundedCache at construction time.
+type Option[V any] func(*BoundedCache[V])
+
+// Config is the resolved configuration of a BoundedCache, as returned by
+// Options.
+type Config struct {
+	// MaxItems is the maximum number of entries the cache holds.
+	MaxItems int
+	// InitialCapacity is the number of entries the fresh map was pre-sized
+	// for.
+	InitialCapacity int
+	// HighWatermark is the FillRatio above which the high watermark hook
+	// fires.
+	HighWatermark float64
+	// LowWatermark is the FillRatio at or below which the high watermark
+	// hook re-arms.
+	LowWatermark float64
+	// OrderedIteration reports whether Range and Keys follow insertion
+	// order.
+	OrderedIteration bool
+	// NegativeTTL is how long GetOrCreateNeg remembers that a key was not
+	// found.
+	NegativeTTL time.Duration
+	// IdleTimeout is how long an entry may go without being written or hit
+	// before it is treated as absent.
+	IdleTimeout time.Duration
+	// RejectNil reports whether nil values are refused.
+	RejectNil bool
+	// SoftTTL is the age after which GetEntry flags an entry as needing a
+	// refresh, and HardTTL the age after which it is treated as absent.
+	SoftTTL time.Duration
+	HardTTL time.Duration
+	// ChurnTracking reports whether evicted keys are remembered so that
+	// ChurnCount can count their return.
+	ChurnTracking bool
+	// DeferredPromotion reports whether stale hits that would force a shift
+	// are served in place the first time.
+	DeferredPromotion bool
+	// LockFreeReads reports whether fresh hits in Get are served from a
+	// lock-free snapshot.
+	LockFreeReads bool
+	// MinFreshBeforeShift is the number of entries the fresh generation
+	// must hold before an insert may shift.
+	MinFreshBeforeShift int
+	// AccessTracking reports whether entries record their last access for
+	// MostRecentlyUsed.
+	AccessTracking bool
+	// EvictionRateLimit is the number of entries per second that shifts may
+	// discard before they are deferred.
+	EvictionRateLimit int
+	// SlowCreateThreshold is the duration at or above which a creation is
+	// reported to the slow create hook.
+	SlowCreateThreshold time.Duration
+	// SingleGeneration reports whether the cache keeps one generation and
+	// discards it whole when full.
+	SingleGeneration bool
+	// MaxConcurrentCreates is the number of off-lock creations that may run
+	// at once.
+	MaxConcurrentCreates int
+	// CaseInsensitiveKeys reports whether keys are lowercased before use.
+	CaseInsensitiveKeys bool
+	// HitCounting reports whether entries count their hits for HitCount.
+	HitCounting bool
+	// MaxLifetime is how long an entry may live after its key was first
+	// stored before it is treated as absent.
+	MaxLifetime time.Duration
+	// StaleHitsAsMisses reports whether stale hits are counted as misses.
+	StaleHitsAsMisses bool
+}
+
+// WithHighWatermark calls cb when an insert pushes FillRatio above ratio. It
+// fires at most once per crossing: the hook re-arms only after an insert
+// observes FillRatio at or below the low watermark, which defaults to ratio
+// and can be lowered with WithLowWatermark. cb runs on its own goroutine so
+// it never blocks the inserting caller.
+func WithHighWatermark[V any](ratio float64, cb func()) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.HighWatermark = ratio
+		c.onHighWater = cb
+	}
+}
+
+// WithLowWatermark sets the FillRatio at or below which the high watermark
+// hook re-arms. Values above the high watermark are clamped to it.
+func WithLowWatermark[V any](ratio float64) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.LowWatermark = ratio
+	}
+}
+
+// WithInitialCapacity pre-sizes the fresh map for n entries, capped at the
+// per-generation limit, to avoid repeated map growth while the cache first
+// fills. By default the map starts unsized.
+func WithInitialCapacity[V any](n int) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.InitialCapacity = n
+	}
+}
+
+// WithOrderedIteration makes Range and Keys visit entries in the order their
+// keys were first inserted, which makes their output reproducible. Promotion
+// and overwrites keep a key's position; a removed or evicted key that is added
+// again moves to the end. The order is kept in an auxiliary slice, which costs
+// one string header per entry, a linear scan on every Remove, and a linear
+// pass on every shift that discards entries.
+func WithOrderedIteration[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.OrderedIteration = true
+	}
+}
+
+// WithNegativeTTL makes GetOrCreateNeg remember not-found results for d.
+func WithNegativeTTL[V any](d time.Duration) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.NegativeTTL = d
+	}
+}
+
+// WithClock replaces time.Now as the cache's source of time. It is mainly
+// useful for tests of time-based behavior.
+func WithClock[V any](now func() time.Time) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.now = now
+	}
+}
+
+// WithSizeEstimator makes EstimatedBytes charge each entry estimate(key,
+// value) bytes, in addition to its map slot, instead of just the key's bytes.
+// It should account for the key and any memory the value references.
+func WithSizeEstimator[V any](estimate func(key string, value V) int64) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.sizeEstimator = estimate
+	}
+}
+
+// WithIdleTimeout expires entries that have not been written or hit by Get or
+// the GetOrCreate family for d. Idle entries are treated as absent by every
+// lookup and are removed lazily when a write-locked lookup finds them; Peek
+// and the other read-only accessors never refresh an entry. Recording access
+// times means every Get takes the write lock, trading the read-mostly fast
+// path for idle tracking.
+func WithIdleTimeout[V any](d time.Duration) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.IdleTimeout = d
+	}
+}
+
+// WithOnEvict calls fn for every value the cache drops, with the reason it
+// was dropped. It does not fire for entries consumed by Drain. fn runs with
+// the cache's lock held, so it must be fast and must not call back into the
+// cache.
+func WithOnEvict[V any](fn func(key string, value V, reason EvictReason)) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.onEvict = fn
+	}
+}
+
+// WithEvictionFilter consults keep for every stale entry a shift would
+// discard. Entries for which keep returns true are carried into the new fresh
+// generation instead. Rescued entries occupy fresh slots, so they make the
+// next shift come sooner; at most one less than the per-generation cap are
+// rescued per shift, leaving room for the insert that caused it, and any
+// further entries keep would rescue are discarded as usual. keep runs with
+// the cache's lock held and must not call back into the cache.
+func WithEvictionFilter[V any](keep func(key string, value V) bool) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.evictionFilter = keep
+	}
+}
+
+// WithHealthCheck validates values on every hit by Get and the GetOrCreate
+// family. A value for which healthy returns false is removed and the lookup
+// is treated as a miss, so GetOrCreate transparently replaces it. healthy
+// runs on every hit, usually with the cache's lock held, so its cost is added
+// to every cached read, and it must not call back into the cache. Peek and
+// the other read-only accessors do not run it.
+func WithHealthCheck[V any](healthy func(V) bool) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.healthCheck = healthy
+	}
+}
+
+// WithRejectNil makes the cache refuse to store nil values: nil interfaces
+// and nil pointers, maps, channels and functions. Refused writes store
+// nothing; Add reports no eviction, TryAdd returns ErrNilValue, GetOrSet
+// reports set=false, CompareAndSwap fails, and the GetOrCreate family returns
+// the nil value without caching it. Detecting nil inside an arbitrary V takes
+// a reflection call on every write, which is why it is off by default.
+func WithRejectNil[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.RejectNil = true
+	}
+}
+
+// WithOnPromote calls fn whenever Get or the GetOrCreate family finds an
+// entry in the stale generation and moves it back to the fresh one, which is
+// exactly when the stale generation saved a value from being recreated. fn
+// runs with the cache's lock held and must not call back into the cache.
+func WithOnPromote[V any](fn func(key string, value V)) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.onPromote = fn
+	}
+}
+
+// WithVictimCache hands entries discarded for capacity to vc instead of
+// dropping them, and makes lookups through Get and the GetOrCreate family
+// that miss this cache consult vc, moving a hit back into this cache. Spilled
+// entries are still alive in vc, so OnEvict does not fire for them. A hit in
+// vc counts as neither a hit nor a miss in this cache's Stats. vc is called
+// with this cache's lock held, so it must not be this cache or call back
+// into it.
+func WithVictimCache[V any](vc Cache[V]) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.victim = vc
+	}
+}
+
+// WithCopyOnRead makes reads return fn(v) instead of the stored value v, so
+// callers can mutate what they get back without affecting the cache or other
+// readers. It applies uniformly to Get, GetEntry, Peek, PeekStatus,
+// GetVersioned, the GetOrCreate family when the value was already cached, and
+// the batch reads PeekMany, GetGroup and TopN. fn is called after the cache's
+// lock is released, so it may be slow, but it must not mutate its argument,
+// which other readers may be holding. Range, Drain, and callbacks see stored
+// values as-is. Without this option no copy is made.
+func WithCopyOnRead[V any](fn func(V) V) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.copyOnRead = fn
+	}
+}
+
+// WithDefaultCreate sets the creator Fetch uses on a miss, for caches where
+// every key is loaded the same way. Get and the other lookups are unaffected.
+func WithDefaultCreate[V any](create func(key string) V) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.defaultCreate = create
+	}
+}
+
+// WithTeardownOrder controls the order in which Purge reports entries to
+// OnEvict, for caches of interdependent resources that must be released in a
+// particular order. Purge passes every cached value to order, which returns
+// them in teardown order, and then fires OnEvict once per entry in that
+// order. Values order leaves out are reported afterwards, and if several keys
+// hold the same value, they are reported in turn each time the value appears.
+// order runs with the write lock held and must not call back into the cache.
+// It is only consulted when OnEvict is set; other evictions, including those
+// made by ReplaceAll, are reported in arbitrary order as before.
+func WithTeardownOrder[V comparable](order func(values []V) []V) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.teardownOrder = func(keys []string, values []V) []string {
+			return teardownKeys(keys, values, order)
+		}
+	}
+}
+
+// WithLockFreeReads makes Get serve hits in the fresh generation from an
+// immutable snapshot of it, read through an atomic pointer without taking any
+// lock, so hit-heavy readers no longer contend on the lock. Writers still
+// serialize on the write lock, and every release of the write lock discards
+// the snapshot; the next Get then copies the whole fresh generation to
+// rebuild it. That makes each write cost O(n) for the following read, so the
+// option only pays off for read-mostly workloads. Misses and stale hits take
+// the write lock as before, which discards the snapshot too, and with
+// WithIdleTimeout Get never uses the snapshot.
+func WithLockFreeReads[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.LockFreeReads = true
+	}
+}
+
+// WithChangeDetector sets a function reporting whether a write of new over
+// old really changes the value. When Add, or any other method that stores a
+// whole value, overwrites an entry and changed returns false, the new value
+// replaces the old one silently: the entry stays in its generation rather
+// than moving to fresh, keeps its version, and OnEvict is not called, so
+// no-op writes cause no generational churn. It works for any V,
+// comparable or not. changed is called with the write lock held, once per
+// write of a key that is already cached, so its cost is added to those
+// writes; it must not call back into the cache.
+func WithChangeDetector[V any](changed func(old, new V) bool) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.changeDetector = changed
+	}
+}
+
+// WithSoftTTL gives entries a soft and a hard freshness limit, measured from
+// the write that stored them. An entry older than hard is treated as absent,
+// like one past an idle timeout. An entry older than soft but not yet hard is
+// still returned, and GetEntry sets its NeedsRefresh flag, so the caller can
+// serve it while deciding whether to reload it. soft must be less than hard;
+// a larger soft is clamped to hard, so entries expire without ever being
+// flagged.
+func WithSoftTTL[V any](soft, hard time.Duration) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.SoftTTL = min(soft, hard)
+		c.cfg.HardTTL = hard
+	}
+}
+
+// WithChurnTracking makes the cache remember the keys it recently evicted for
+// lack of room, so that ChurnCount can report how many of them were stored
+// again. At most one generation cap's worth of evicted keys is remembered
+// per generation, two in total, so the memory used is bounded by the keys
+// alone. Every shift then visits the evicted entries, giving up the shift
+// fast path.
+func WithChurnTracking[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.ChurnTracking = true
+	}
+}
+
+// WithMemoryPressureHook calls check whenever a write stores a key the fresh
+// generation does not hold yet. When check reports memory pressure, the cache
+// shifts right away, discarding the stale generation as if the fresh one had
+// filled, so under sustained pressure it keeps shedding entries on every such
+// write instead of growing back to MaxItems. Discarded entries are reported
+// like any other shift. check runs with the write lock held on the insert
+// path, so it must be fast, such as reading a flag maintained elsewhere, and
+// must not call back into the cache.
+func WithMemoryPressureHook[V any](check func() bool) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.underPressure = check
+	}
+}
+
+// WithDeferredPromotion stops lightly used stale entries from forcing early
+// shifts. When the fresh generation is full, so that promoting a stale hit
+// would shift immediately, the first such hit on an entry returns its value
+// but leaves it in the stale generation; only a second hit while it is still
+// stale promotes it. Hits while the fresh generation has room promote as
+// usual. This damps the sawtooth of shifts caused by one-off hits on a full
+// cache, at the cost of entries hit only once being evicted sooner.
+func WithDeferredPromotion[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.DeferredPromotion = true
+	}
+}
+
+// WithOnExpire calls fn for every entry the cache drops because its idle
+// timeout, hard TTL or max lifetime ran out, as opposed to being shifted out
+// for capacity; the first limit reached expires the entry, as described for
+// WithMaxLifetime. Expired entries are dropped lazily, when a lookup or BulkLoad comes across
+// them; OnEvict still fires for them too, with ReasonExpired, after fn. An
+// entry that expires but is never looked up again leaves with its generation
+// and is reported as a shift. fn runs with the cache's lock held and must
+// not call back into the cache.
+func WithOnExpire[V any](fn func(key string, value V)) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.onExpire = fn
+	}
+}
+
+// WithMinFreshBeforeShift keeps the fresh generation from shifting until it
+// holds at least n entries, even when that is more than its usual cap of
+// MaxItems/2. On tiny caches, where the cap is one or two entries and nearly
+// every insert would otherwise shift, this stops the cache from thrashing.
+// The price is a transient overshoot: with n above the cap the cache can
+// hold up to 2n entries, more than MaxItems, until a Resize or Rebalance
+// trims it. Values at or below the cap have no effect.
+func WithMinFreshBeforeShift[V any](n int) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.MinFreshBeforeShift = n
+	}
+}
+
+// WithPanicHandler recovers panics raised by the hooks installed with other
+// options, such as OnEvict, OnPromote, OnExpire, the event hook and the
+// eviction filter, and passes them to handle, so that one faulty hook cannot
+// abandon the cache halfway through an update. The operation then carries
+// on as if the hook had not been installed: a panicking eviction filter
+// keeps nothing, a health check reports healthy, a change detector reports a
+// change, a teardown order leaves the keys as they were, and a size estimator
+// counts nothing extra.
+//
+// Functions that produce values, such as create functions, WithDefaultCreate
+// and WithCopyOnRead, are not covered; their panics, like those of hooks
+// without a handler, reach the caller after the cache releases its lock.
+// handle may run with the lock held and must not call back into the cache.
+func WithPanicHandler[V any](handle func(recovered any)) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.panicHandler = handle
+	}
+}
+
+// WithAccessTracking stamps each entry with a reading of a counter bumped on
+// every write and hit, so that MostRecentlyUsed can rank keys by recency
+// without maintaining an LRU list. The stamp is a write on every read hit:
+// with tracking enabled Get takes the write lock even for fresh hits, so
+// concurrent readers serialize as they do under WithIdleTimeout.
+func WithAccessTracking[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.AccessTracking = true
+	}
+}
+
+// WithEvictionRateLimit smooths evictions, and the OnEvict calls they make,
+// to about perSecond entries per second. When the fresh generation fills but
+// the budget cannot cover discarding the stale generation, the shift is
+// deferred and the fresh generation keeps growing past its cap, trading
+// memory for a bounded eviction rate. The budget is a token bucket holding
+// up to perSecond tokens, refilled from the cache's clock; a stale
+// generation larger than that is discarded once the bucket is full, leaving
+// it in debt until the excess has been paid back.
+//
+// Deferral is bounded: once the fresh generation reaches twice the size that
+// would normally shift, it shifts regardless of the budget. The cache
+// therefore holds at most twice as many entries as it would without the
+// limit, twice MaxItems unless WithMinFreshBeforeShift raises it, and a rate
+// too low for the write load degrades to that bound rather than to unbounded
+// growth. Shifts forced by WithMemoryPressureHook are not rate limited.
+func WithEvictionRateLimit[V any](perSecond int) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.EvictionRateLimit = perSecond
+	}
+}
+
+// WithCopyOnPromote stores fn(v) in the fresh generation when a stale entry
+// holding v is promoted by Get or the GetOrCreate family, so that the
+// promoted value is independent of any reference to the stale one still
+// held elsewhere. The copy is made first: OnPromote and the lookup that
+// promoted the entry both see the copy, and WithCopyOnRead, if set, copies
+// it again for the caller. Stale hits held back by WithDeferredPromotion are
+// not copied. Promotion happens under the write lock, so fn runs with it held
+// and must not call back into the cache; keep it as cheap as the values
+// allow.
+func WithCopyOnPromote[V any](fn func(V) V) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.copyOnPromote = fn
+	}
+}
+
+// WithSlowCreateHook calls fn for every creation by the GetOrCreate family
+// that runs off-lock and takes at least threshold on the cache's clock, with
+// the key, the time the creation started, and how long it took. It is the
+// building block for tracing slow fills without instrumenting every creator;
+// with OpenTelemetry, for example, fn can record a span after the fact by
+// passing trace.WithTimestamp(start) to Start and
+// trace.WithTimestamp(start.Add(took)) to End. The cache does not depend on a
+// tracing library itself. fn runs on the creating goroutine after create
+// returns and without the lock held, so it delays that caller only. Without
+// this option creations are not timed.
+func WithSlowCreateHook[V any](threshold time.Duration, fn func(key string, start time.Time, took time.Duration)) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.SlowCreateThreshold = threshold
+		c.onSlowCreate = fn
+	}
+}
+
+// WithSingleGeneration turns the cache into a plain bounded map: a single
+// generation holds up to MaxItems entries, and the insert that finds it full
+// discards all of them, less any rescued by the eviction filter or carried
+// over by priority, instead of demoting them to a stale generation. There
+// are no second chances, so Peek never reports an entry as stale, AddTo
+// stores into the one generation regardless of its stale argument, and hit
+// ratios are lower for the same MaxItems; in exchange the cache keeps one
+// map instead of two. Shifts are reported as usual. Once the generation is
+// full, PeekStatus reports every entry as StatusAboutToDrop and
+// EvictionCandidates returns all its keys, since the next insert of a new key
+// discards them.
+func WithSingleGeneration[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.SingleGeneration = true
+	}
+}
+
+// WithMaxConcurrentCreates allows at most n creators, across all keys, to
+// run at once in the GetOrCreate family, as backpressure on a backend the
+// creators share. A miss that finds every slot taken waits for one, or with
+// TryGetOrCreate fails with ErrCreateBusy; CreateLimitHits counts both. Only
+// create itself holds a slot, never the cache's lock, so hits are not
+// slowed. The limit counts calls of create, not keys: concurrent misses on
+// one key each take a slot, since GetOrCreate does not coalesce them.
+// GetOrCreateFuture holds a slot only while it installs the future, not
+// while the computation runs, so its computations are not limited. Slow
+// create hook durations exclude the wait for a slot.
+func WithMaxConcurrentCreates[V any](n int) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.MaxConcurrentCreates = n
+	}
+}
+
+// WithCaseInsensitiveKeys makes keys that differ only in case refer to the
+// same entry, by lowercasing every key with strings.ToLower before it is
+// used. The lowercased form is what the cache stores, so it is what Keys,
+// Range, KeysPage and the other enumerations return, what hooks such as
+// OnEvict receive, and what GetOrCreateKey passes to its creator; PeekMany
+// alone keys its result by the keys as given. Keys that are already
+// lowercase are used as they are, without allocating.
+func WithCaseInsensitiveKeys[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.CaseInsensitiveKeys = true
+	}
+}
+
+// WithHitCounting makes every entry count the hits it receives from Get and
+// the GetOrCreate family, for HitCount and hot-key reports. Like
+// WithAccessTracking it turns every read hit into a write: Get takes the
+// write lock even for fresh hits, so concurrent readers serialize.
+func WithHitCounting[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.HitCounting = true
+	}
+}
+
+// WithFallback consults fb whenever a lookup by Get or the GetOrCreate family
+// misses, after the victim cache if there is one, and on a hit there stores
+// the value in the cache as a new write and returns it as a hit, so that,
+// for example, a per-request cache can sit in front of a shared one. Unlike
+// a victim cache, fb is only ever read: entries the cache adds or evicts are
+// never written back to it, and its contents are left for its owner to
+// manage. A miss in fb is a miss, so GetOrCreate goes on to create the value
+// and stores it only in this cache, while a hit in fb counts as neither a
+// hit nor a miss in Stats. fb is read with this cache's write lock
+// held, so it must not read from this cache in turn, directly or through
+// other fallbacks.
+func WithFallback[V any](fb Reader[V]) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.fallback = fb
+	}
+}
+
+// WithMaxLifetime caps how long any entry can live: once d has passed since
+// its key was first stored, the entry is treated as absent, however often it
+// has been hit, promoted or overwritten since. Only removal, eviction or
+// expiry starts a key's lifetime over, so a hot key that is kept in the cache
+// by promotion, or rewritten in place, still drops out after d and has to be
+// created afresh.
+//
+// It combines with the other timers rather than overriding them. An entry is
+// absent as soon as any limit is reached: the idle timeout of
+// WithIdleTimeout, which counts from the last write or hit; the hard TTL of
+// WithSoftTTL, which counts from the last write; or d, which counts from
+// creation. The soft TTL never makes an entry absent, it only flags it for
+// refresh.
+func WithMaxLifetime[V any](d time.Duration) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.MaxLifetime = d
+	}
+}
+
+// WithStaleHitsAsMisses counts every hit served by the stale generation as a
+// miss in Stats instead of a stale hit, so that HitRatio measures how often
+// lookups find fresh data rather than any data. It changes the counters
+// only: stale hits are still returned and promoted as before. StaleHits
+// stays zero and StaleHitRatio returns 0, so the option does not suit
+// sizing the stale generation.
+func WithStaleHitsAsMisses[V any]() Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.cfg.StaleHitsAsMisses = true
+	}
+}
+
+// WithStore backs each generation with a Store returned by newStore instead
+// of a Go map, so that very large caches can keep their entries off-heap or
+// in a custom structure while the cache keeps its generational eviction.
+// newStore is called whenever the cache needs an empty generation, at
+// construction and on every shift, with the number of entries it expects
+// the store to hold as a sizing hint.
+func WithStore[V any](newStore func(capacity int) Store[V]) Option[V] {
+	return func(c *BoundedCache[V]) {
+		c.storeFactory = func(capacity int) store[V] {
+			return userStore[V]{newStore(capacity)}
+		}
+	}
+}
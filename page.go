@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"container/heap"
+	"slices"
+	"strings"
+)
+
+// pageCursorPrefix marks a KeysPage cursor, so that a page ending in the
+// empty key still yields a cursor distinct from the starting one.
+const pageCursorPrefix = "after:"
+
+// KeysPage returns up to limit keys of live entries in lexical order,
+// starting after the position recorded in cursor, together with the cursor
+// for the next page. Pass "" to start from the beginning; nextCursor is ""
+// once no keys remain. Cursors are opaque and should only be obtained from
+// KeysPage.
+//
+// No snapshot is kept between calls: each page is taken from the live cache,
+// so a cursor never expires and costs nothing to abandon. In exchange a scan
+// is not a consistent view: a key added behind the cursor is skipped, one
+// added ahead of it is returned, and a removed key simply does not appear.
+// Every page visits every entry under the read lock, in O(m log limit) time
+// for m entries.
+func (c *BoundedCache[V]) KeysPage(cursor string, limit int) (keys []string, nextCursor string) {
+	if limit <= 0 {
+		return nil, cursor
+	}
+	after, resume := strings.CutPrefix(cursor, pageCursorPrefix)
+	// Keep the limit+1 smallest keys past the cursor, greatest at the root;
+	// the extra key tells whether another page follows.
+	h := &valueHeap[string]{less: func(a, b string) bool { return a > b }}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, items := range []store[V]{c.freshItems, c.staleItems} {
+		for key, e := range items.all() {
+			if resume && key <= after || c.expired(e) {
+				continue
+			}
+			switch {
+			case h.Len() <= limit:
+				heap.Push(h, key)
+			case key < h.values[0]:
+				h.values[0] = key
+				heap.Fix(h, 0)
+			}
+		}
+	}
+	keys = h.values
+	slices.Sort(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+		nextCursor = pageCursorPrefix + keys[limit-1]
+	}
+	return keys, nextCursor
+}
@@ -0,0 +1,93 @@
+package cache
+
+import "time"
+
+// guardHooks wraps every hook installed by an option so that a panic in it
+// is recovered and passed to the panic handler instead of unwinding through
+// the cache mid-update. A hook that panics yields the result that leaves the
+// cache's behavior unchanged, as if the hook had not been installed.
+func (c *BoundedCache[V]) guardHooks() {
+	if fn := c.onHighWater; fn != nil {
+		c.onHighWater = func() {
+			defer c.recoverHook()
+			fn()
+		}
+	}
+	if fn := c.onEvent; fn != nil {
+		c.onEvent = func(ev Event) {
+			defer c.recoverHook()
+			fn(ev)
+		}
+	}
+	if fn := c.sizeEstimator; fn != nil {
+		c.sizeEstimator = func(key string, value V) (n int64) {
+			defer c.recoverHook()
+			return fn(key, value)
+		}
+	}
+	if fn := c.onEvict; fn != nil {
+		c.onEvict = func(key string, value V, reason EvictReason) {
+			defer c.recoverHook()
+			fn(key, value, reason)
+		}
+	}
+	if fn := c.evictionFilter; fn != nil {
+		c.evictionFilter = func(key string, value V) (keep bool) {
+			defer c.recoverHook()
+			return fn(key, value)
+		}
+	}
+	if fn := c.healthCheck; fn != nil {
+		c.healthCheck = func(value V) (healthy bool) {
+			healthy = true
+			defer c.recoverHook()
+			return fn(value)
+		}
+	}
+	if fn := c.onPromote; fn != nil {
+		c.onPromote = func(key string, value V) {
+			defer c.recoverHook()
+			fn(key, value)
+		}
+	}
+	if fn := c.onExpire; fn != nil {
+		c.onExpire = func(key string, value V) {
+			defer c.recoverHook()
+			fn(key, value)
+		}
+	}
+	if fn := c.changeDetector; fn != nil {
+		c.changeDetector = func(old, new V) (changed bool) {
+			changed = true
+			defer c.recoverHook()
+			return fn(old, new)
+		}
+	}
+	if fn := c.underPressure; fn != nil {
+		c.underPressure = func() (pressure bool) {
+			defer c.recoverHook()
+			return fn()
+		}
+	}
+	if fn := c.onSlowCreate; fn != nil {
+		c.onSlowCreate = func(key string, start time.Time, took time.Duration) {
+			defer c.recoverHook()
+			fn(key, start, took)
+		}
+	}
+	if fn := c.teardownOrder; fn != nil {
+		c.teardownOrder = func(keys []string, values []V) (order []string) {
+			order = keys
+			defer c.recoverHook()
+			return fn(keys, values)
+		}
+	}
+}
+
+// recoverHook passes a panic raised by a hook to the panic handler. It must
+// be deferred directly by the hook's wrapper.
+func (c *BoundedCache[V]) recoverHook() {
+	if r := recover(); r != nil {
+		c.panicHandler(r)
+	}
+}
@@ -0,0 +1,56 @@
+package cache
+
+import "time"
+
+// evictionBudget is the token bucket behind WithEvictionRateLimit. Each
+// token pays for discarding one entry.
+type evictionBudget struct {
+	rate   float64 // tokens added per second, and the bucket's capacity
+	tokens float64
+	last   time.Time
+}
+
+func newEvictionBudget(perSecond int, now time.Time) *evictionBudget {
+	return &evictionBudget{rate: float64(perSecond), tokens: float64(perSecond), last: now}
+}
+
+// take reports whether the budget allows n evictions now and, if so, spends
+// them. Batches larger than the bucket are allowed once it is full and leave
+// it in debt.
+func (b *evictionBudget) take(n int, now time.Time) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, b.rate)
+		b.last = now
+	}
+	if b.tokens < min(float64(n), b.rate) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// shiftAllowed reports whether an insert that filled the fresh generation
+// may shift now, charging the entries the shift will discard to the eviction
+// budget. The caller must hold the write lock.
+func (c *BoundedCache[V]) shiftAllowed() bool {
+	if c.evictions == nil {
+		return true
+	}
+	n := c.staleItems.len()
+	if c.cfg.SingleGeneration {
+		n = c.freshItems.len()
+	}
+	if n == 0 {
+		// Nothing is discarded, so there is nothing to ration.
+		return true
+	}
+	if c.evictions.take(n, c.now()) {
+		return true
+	}
+	if c.freshItems.len() >= 2*c.shiftAt() {
+		// The overshoot bound is reached; shift anyway and go into debt.
+		c.evictions.tokens -= float64(n)
+		return true
+	}
+	return false
+}
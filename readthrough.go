@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReadThrough is a loading cache built on BoundedCache. Values expire a fixed
+// TTL after they were loaded, concurrent loads of the same key are coalesced
+// into one call, and a value whose reload fails keeps being served for a
+// grace period after it expired.
+type ReadThrough[V any] struct {
+	cache *BoundedCache[loaded[V]]
+	load  func(ctx context.Context, key string) (V, error)
+	ttl   time.Duration
+	cfg   readThroughConfig
+
+	mu       sync.Mutex
+	inFlight map[string]*loadCall[V]
+	loading  atomic.Int64
+}
+
+// loaded is a value together with the time it was loaded.
+type loaded[V any] struct {
+	value V
+	at    time.Time
+}
+
+// loadCall is a load in progress that other callers can wait on.
+type loadCall[V any] struct {
+	done chan struct{}
+	val  V
+	err  error
+}
+
+type readThroughConfig struct {
+	grace time.Duration
+	now   func() time.Time
+}
+
+// ReadThroughOption configures a ReadThrough.
+type ReadThroughOption func(*readThroughConfig)
+
+// WithStaleGrace lets a ReadThrough serve an expired value for up to d past
+// its TTL when reloading it fails. By default load errors are returned as
+// soon as the value has expired.
+func WithStaleGrace(d time.Duration) ReadThroughOption {
+	return func(cfg *readThroughConfig) {
+		cfg.grace = d
+	}
+}
+
+// WithReadThroughClock replaces time.Now as the ReadThrough's source of time.
+func WithReadThroughClock(now func() time.Time) ReadThroughOption {
+	return func(cfg *readThroughConfig) {
+		cfg.now = now
+	}
+}
+
+// NewReadThrough returns a ReadThrough holding at most maxItems values, each
+// considered fresh for ttl after load returned it.
+func NewReadThrough[V any](maxItems int, ttl time.Duration, load func(ctx context.Context, key string) (V, error), opts ...ReadThroughOption) *ReadThrough[V] {
+	cfg := readThroughConfig{now: time.Now}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return &ReadThrough[V]{
+		cache:    NewBoundedCache[loaded[V]](maxItems),
+		load:     load,
+		ttl:      ttl,
+		cfg:      cfg,
+		inFlight: make(map[string]*loadCall[V]),
+	}
+}
+
+// Get returns the value for key. A value loaded less than the TTL ago is
+// returned straight from the cache. Otherwise Get loads it, sharing the load
+// with any concurrent callers for the same key. If the load fails and the
+// expired value is still within the grace period, the expired value is
+// returned instead of the error. ctx bounds how long this caller waits; the
+// shared load itself runs with the context of the caller that started it.
+// If load panics, the panic propagates to the caller that started the load,
+// and the callers sharing it get a *PanicError.
+func (rt *ReadThrough[V]) Get(ctx context.Context, key string) (V, error) {
+	cached, ok, _ := rt.cache.Get(key)
+	age := rt.cfg.now().Sub(cached.at)
+	if ok && age < rt.ttl {
+		return cached.value, nil
+	}
+
+	val, err := rt.reload(ctx, key)
+	if err != nil && ok && age < rt.ttl+rt.cfg.grace {
+		return cached.value, nil
+	}
+	return val, err
+}
+
+// InFlight returns the number of distinct keys currently being loaded. A
+// sustained rise usually means the backing store has slowed down.
+func (rt *ReadThrough[V]) InFlight() int {
+	return int(rt.loading.Load())
+}
+
+// reload loads key, joining a load already in flight for it.
+func (rt *ReadThrough[V]) reload(ctx context.Context, key string) (V, error) {
+	rt.mu.Lock()
+	call, ok := rt.inFlight[key]
+	if !ok {
+		call = &loadCall[V]{done: make(chan struct{})}
+		rt.inFlight[key] = call
+		rt.loading.Add(1)
+	}
+	rt.mu.Unlock()
+
+	if ok {
+		select {
+		case <-call.done:
+			return call.val, call.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+
+	defer func() {
+		// A panicking load fails the callers coalesced into it with a
+		// PanicError and is re-raised in the caller that ran it.
+		r := recover()
+		if r != nil {
+			call.err = &PanicError{Value: r}
+		}
+		rt.mu.Lock()
+		delete(rt.inFlight, key)
+		rt.loading.Add(-1)
+		rt.mu.Unlock()
+		close(call.done)
+		if r != nil {
+			panic(r)
+		}
+	}()
+	call.val, call.err = rt.load(ctx, key)
+	if call.err == nil {
+		rt.cache.Add(key, loaded[V]{value: call.val, at: rt.cfg.now()})
+	}
+	return call.val, call.err
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadThroughTTL(t *testing.T) {
+	clock := newFakeClock()
+	loads := 0
+	rt := NewReadThrough(10, time.Minute, func(ctx context.Context, key string) (int, error) {
+		loads++
+		return loads, nil
+	}, WithReadThroughClock(clock.Now))
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if v, err := rt.Get(ctx, "k"); err != nil || v != 1 {
+			t.Fatalf("Get = %v, %v; want 1, nil", v, err)
+		}
+	}
+	clock.Advance(time.Minute)
+	if v, err := rt.Get(ctx, "k"); err != nil || v != 2 {
+		t.Fatalf("Get after TTL = %v, %v; want reloaded 2, nil", v, err)
+	}
+}
+
+func TestReadThroughServeStaleOnError(t *testing.T) {
+	clock := newFakeClock()
+	errDown := errors.New("backend down")
+	fail := false
+	rt := NewReadThrough(10, time.Minute, func(ctx context.Context, key string) (string, error) {
+		if fail {
+			return "", errDown
+		}
+		return "v", nil
+	}, WithStaleGrace(time.Minute), WithReadThroughClock(clock.Now))
+
+	ctx := context.Background()
+	rt.Get(ctx, "k")
+	fail = true
+
+	clock.Advance(90 * time.Second)
+	if v, err := rt.Get(ctx, "k"); err != nil || v != "v" {
+		t.Fatalf("Get within grace = %q, %v; want stale value", v, err)
+	}
+	clock.Advance(time.Minute)
+	if _, err := rt.Get(ctx, "k"); !errors.Is(err, errDown) {
+		t.Fatalf("Get past grace error = %v, want %v", err, errDown)
+	}
+}
+
+func TestReadThroughCoalescesLoads(t *testing.T) {
+	var loads atomic.Int32
+	release := make(chan struct{})
+	rt := NewReadThrough(10, time.Minute, func(ctx context.Context, key string) (int, error) {
+		loads.Add(1)
+		<-release
+		return 7, nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := rt.Get(context.Background(), "k"); err != nil || v != 7 {
+				t.Errorf("Get = %v, %v; want 7, nil", v, err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := rt.InFlight(); n != 1 {
+		t.Errorf("InFlight() during load = %d, want 1", n)
+	}
+	close(release)
+	wg.Wait()
+	if n := rt.InFlight(); n != 0 {
+		t.Errorf("InFlight() after load = %d, want 0", n)
+	}
+	if n := loads.Load(); n != 1 {
+		t.Fatalf("load called %d times, want 1", n)
+	}
+}
+
+func TestReadThroughLoadPanic(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	rt := NewReadThrough(10, time.Minute, func(ctx context.Context, key string) (int, error) {
+		close(started)
+		<-release
+		panic("boom")
+	})
+
+	recovered := make(chan any)
+	go func() {
+		defer func() { recovered <- recover() }()
+		rt.Get(context.Background(), "k")
+	}()
+	<-started
+
+	shared := make(chan error)
+	go func() {
+		_, err := rt.Get(context.Background(), "k")
+		shared <- err
+	}()
+	for rt.InFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // let the second caller join the load
+	close(release)
+
+	if r := <-recovered; r != "boom" {
+		t.Fatalf("loading caller recovered %v, want boom", r)
+	}
+	var pe *PanicError
+	if err := <-shared; !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Fatalf("waiting caller got %v, want a PanicError carrying boom", err)
+	}
+}
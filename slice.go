@@ -0,0 +1,32 @@
+package cache
+
+// SliceCache is a BoundedCache of slices with an atomic append, suited to
+// caches of growing lists.
+type SliceCache[T any] struct {
+	*BoundedCache[[]T]
+}
+
+// NewSliceCache returns a SliceCache that holds at most maxItems entries.
+func NewSliceCache[T any](maxItems int, opts ...Option[[]T]) *SliceCache[T] {
+	return &SliceCache[T]{NewBoundedCache(maxItems, opts...)}
+}
+
+// Append appends items to the slice stored under key, treating a missing key
+// as an empty slice, stores the result in the fresh generation and returns
+// its length. The read, the append and the write happen under a single lock
+// acquisition, so concurrent appends to one key are never lost. evicted
+// reports whether storing the result discarded stale entries.
+//
+// Append grows the stored slice with the built-in append, reusing its backing
+// array while capacity remains. Slices previously returned by the cache keep
+// their own length and never see the new items, but callers must not append
+// to them themselves, since that would write into the array Append reuses;
+// WithCopyOnRead avoids the sharing altogether.
+func (c *SliceCache[T]) Append(key string, items ...T) (newLen int, evicted bool) {
+	key = c.foldKey(key)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	old, _, evicted := c.getLocked(key)
+	grown := append(old, items...)
+	return len(grown), c.add(key, grown) || evicted
+}
@@ -0,0 +1,109 @@
+package cache
+
+import "iter"
+
+// store holds the entries of one generation. BoundedCache keeps the
+// generational logic and reaches its entries only through this interface, so
+// that a backend other than Go maps, such as an off-heap store, can be
+// swapped in through BoundedCache.storeFactory. mapStore is the default, and
+// WithStore installs a user-supplied Store through userStore.
+//
+// Implementations need not be safe for concurrent use; the cache's lock
+// serializes writers. all must tolerate the deletion of the key it has just
+// yielded.
+type store[V any] interface {
+	get(key string) (entry[V], bool)
+	set(key string, e entry[V])
+	delete(key string)
+	len() int
+	clear()
+	all() iter.Seq2[string, entry[V]]
+}
+
+// Store holds the entries of one generation of a BoundedCache, for
+// backends other than the default Go map, installed with WithStore. The
+// cache keeps the generational logic and only asks a Store to keep entries
+// by key: Get, Set and Delete act on one key, Len and Clear on the whole
+// store, and All visits every entry in any order.
+//
+// A Store need not be safe for concurrent use; the cache's lock serializes
+// its writers, though readers holding the read lock may call Get, Len and
+// All at the same time. All must tolerate the deletion of the key it has
+// just yielded.
+type Store[V any] interface {
+	Get(key string) (StoredEntry[V], bool)
+	Set(key string, e StoredEntry[V])
+	Delete(key string)
+	Len() int
+	Clear()
+	All() iter.Seq2[string, StoredEntry[V]]
+}
+
+// StoredEntry is a value together with the bookkeeping the cache keeps for
+// it, such as its version and timestamps. It is opaque: a Store holds it as
+// given and returns it unchanged.
+type StoredEntry[V any] struct {
+	e entry[V]
+}
+
+// Value returns the cached value held in the entry.
+func (se StoredEntry[V]) Value() V {
+	return se.e.value
+}
+
+// userStore adapts a Store to the store interface.
+type userStore[V any] struct {
+	s Store[V]
+}
+
+func (u userStore[V]) get(key string) (entry[V], bool) {
+	se, ok := u.s.Get(key)
+	return se.e, ok
+}
+
+func (u userStore[V]) set(key string, e entry[V]) { u.s.Set(key, StoredEntry[V]{e}) }
+func (u userStore[V]) delete(key string)          { u.s.Delete(key) }
+func (u userStore[V]) len() int                   { return u.s.Len() }
+func (u userStore[V]) clear()                     { u.s.Clear() }
+
+func (u userStore[V]) all() iter.Seq2[string, entry[V]] {
+	return func(yield func(string, entry[V]) bool) {
+		for key, se := range u.s.All() {
+			if !yield(key, se.e) {
+				return
+			}
+		}
+	}
+}
+
+// mapStore is the default store, a plain Go map.
+type mapStore[V any] map[string]entry[V]
+
+// makeStore returns an empty store for a generation, pre-sized for capacity
+// entries.
+func (c *BoundedCache[V]) makeStore(capacity int) store[V] {
+	if c.storeFactory == nil {
+		return make(mapStore[V], capacity)
+	}
+	return c.storeFactory(capacity)
+}
+
+func (m mapStore[V]) get(key string) (entry[V], bool) {
+	e, ok := m[key]
+	return e, ok
+}
+
+func (m mapStore[V]) set(key string, e entry[V]) { m[key] = e }
+func (m mapStore[V]) delete(key string)          { delete(m, key) }
+func (m mapStore[V]) len() int                   { return len(m) }
+func (m mapStore[V]) clear()                     { clear(m) }
+
+func (m mapStore[V]) all() iter.Seq2[string, entry[V]] {
+	return func(yield func(string, entry[V]) bool) {
+		for key, e := range m {
+			if !yield(key, e) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"container/heap"
+	"slices"
+)
+
+// TopN returns the n greatest cached values according to less, greatest
+// first, without promoting anything. Values are selected with a bounded heap
+// while the read lock is held, in O(m log n) time for m entries, so less
+// must not call back into the cache. The result is a snapshot: writes that
+// land after TopN releases the lock are not reflected, and values that
+// compare equal are returned in unspecified order.
+func (c *BoundedCache[V]) TopN(n int, less func(a, b V) bool) []V {
+	if n <= 0 {
+		return nil
+	}
+	h := &valueHeap[V]{less: less}
+	func() {
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		c.rangeLocked(func(_ string, e entry[V]) bool {
+			switch {
+			case len(h.values) < n:
+				heap.Push(h, e.value)
+			case less(h.values[0], e.value):
+				h.values[0] = e.value
+				heap.Fix(h, 0)
+			}
+			return true
+		})
+	}()
+
+	top := h.values
+	slices.SortFunc(top, func(a, b V) int {
+		switch {
+		case less(b, a):
+			return -1
+		case less(a, b):
+			return 1
+		}
+		return 0
+	})
+	for i, v := range top {
+		top[i] = c.copyOut(v)
+	}
+	return top
+}
+
+// valueHeap is a min-heap of values ordered by less, used by TopN to keep
+// the greatest values seen so far with the smallest of them at the root.
+type valueHeap[V any] struct {
+	values []V
+	less   func(a, b V) bool
+}
+
+func (h *valueHeap[V]) Len() int           { return len(h.values) }
+func (h *valueHeap[V]) Less(i, j int) bool { return h.less(h.values[i], h.values[j]) }
+func (h *valueHeap[V]) Swap(i, j int)      { h.values[i], h.values[j] = h.values[j], h.values[i] }
+func (h *valueHeap[V]) Push(x any)         { h.values = append(h.values, x.(V)) }
+
+func (h *valueHeap[V]) Pop() any {
+	v := h.values[len(h.values)-1]
+	h.values = h.values[:len(h.values)-1]
+	return v
+}
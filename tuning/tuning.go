@@ -0,0 +1,68 @@
+// Package tuning provides offline helpers for sizing a BoundedCache from
+// recorded access patterns.
+package tuning
+
+import (
+	cache "github.com/ryderlewis/boundedcache"
+)
+
+// EstimateMaxItems returns the smallest maxItems for which a BoundedCache
+// replaying accessTrace reaches targetHitRatio, or -1 if no size can: the
+// first access to each key always misses, so the best possible ratio is
+// 1 - distinct/len(accessTrace). Each access is simulated as a Get, followed
+// by an Add on a miss, through the cache's own generational eviction.
+// An empty trace or a target of zero or less needs no cache and returns 0.
+//
+// The search doubles the size until the target is met and then bisects, so
+// it treats the hit ratio as non-decreasing in maxItems. That holds closely
+// for generational eviction but not strictly, and on a few traces a slightly
+// smaller size may also hit the target. Sizes are even, since the cache
+// splits maxItems evenly between its generations.
+//
+// Every probe replays the whole trace, so the cost is O(len(accessTrace) *
+// log(distinct keys)) cache operations, and memory is proportional to the
+// number of distinct keys. A trace of millions of accesses takes seconds.
+func EstimateMaxItems(accessTrace []string, targetHitRatio float64) int {
+	if len(accessTrace) == 0 || targetHitRatio <= 0 {
+		return 0
+	}
+	distinct := make(map[string]struct{})
+	for _, key := range accessTrace {
+		distinct[key] = struct{}{}
+	}
+	// With each generation able to hold every key nothing is ever evicted.
+	limit := len(distinct)
+	if hitRatio(accessTrace, 2*limit) < targetHitRatio {
+		return -1
+	}
+
+	// Search over the per-generation cap.
+	lo, hi := 0, 1
+	for hi < limit && hitRatio(accessTrace, 2*hi) < targetHitRatio {
+		lo, hi = hi, min(2*hi, limit)
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		if hitRatio(accessTrace, 2*mid) >= targetHitRatio {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return 2 * hi
+}
+
+// hitRatio replays trace through a cache of maxItems entries and returns the
+// fraction of accesses that hit.
+func hitRatio(trace []string, maxItems int) float64 {
+	c := cache.NewUnsafeBoundedCache[struct{}](maxItems)
+	hits := 0
+	for _, key := range trace {
+		if _, ok, _ := c.Get(key); ok {
+			hits++
+		} else {
+			c.Add(key, struct{}{})
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
@@ -0,0 +1,35 @@
+package tuning
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestEstimateMaxItems(t *testing.T) {
+	// Ten keys cycled twenty times: a cache that holds all ten hits 95% of
+	// the time and one that cannot thrashes.
+	var trace []string
+	for range 20 {
+		for i := range 10 {
+			trace = append(trace, strconv.Itoa(i))
+		}
+	}
+
+	n := EstimateMaxItems(trace, 0.9)
+	if n <= 0 {
+		t.Fatalf("EstimateMaxItems(0.9) = %d, want a size", n)
+	}
+	if got := hitRatio(trace, n); got < 0.9 {
+		t.Fatalf("hit ratio at %d = %v, want at least 0.9", n, got)
+	}
+	if got := hitRatio(trace, n-2); got >= 0.9 {
+		t.Fatalf("hit ratio at %d = %v also meets the target; %d is not the smallest", n-2, got, n)
+	}
+
+	if n := EstimateMaxItems(trace, 0.99); n != -1 {
+		t.Fatalf("EstimateMaxItems(0.99) = %d, want -1 for an unreachable target", n)
+	}
+	if n := EstimateMaxItems(nil, 0.5); n != 0 {
+		t.Fatalf("EstimateMaxItems(nil) = %d, want 0", n)
+	}
+}
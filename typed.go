@@ -0,0 +1,46 @@
+package cache
+
+// TypedCache is a view of a BoundedCache of encoded bytes that reads and
+// writes typed values, so that a byte cache can serve callers that work with
+// T. The bytes are what the cache stores, bounds and evicts; values are
+// encoded on every Set and decoded on every Get, and the decoded form is
+// never cached, so each read pays for unmarshal.
+type TypedCache[T any] struct {
+	cache     *BoundedCache[[]byte]
+	marshal   func(T) ([]byte, error)
+	unmarshal func([]byte) (T, error)
+}
+
+// NewTypedCache returns a TypedCache that stores values in c encoded with
+// marshal and decodes them with unmarshal. c stays usable directly, and
+// callers of the TypedCache see whatever bytes are written to it.
+func NewTypedCache[T any](c *BoundedCache[[]byte], marshal func(T) ([]byte, error), unmarshal func([]byte) (T, error)) *TypedCache[T] {
+	return &TypedCache[T]{cache: c, marshal: marshal, unmarshal: unmarshal}
+}
+
+// Get decodes the value stored under key, promoting it if stale like
+// BoundedCache.Get. ok is false on a miss. If the stored bytes fail to
+// decode, Get returns the zero T, ok=false and the error from unmarshal; the
+// entry is left in the cache.
+func (tc *TypedCache[T]) Get(key string) (val T, ok bool, err error) {
+	b, ok, _ := tc.cache.Get(key)
+	if !ok {
+		return val, false, nil
+	}
+	if val, err = tc.unmarshal(b); err != nil {
+		var zero T
+		return zero, false, err
+	}
+	return val, true, nil
+}
+
+// Set encodes v and stores the bytes under key like BoundedCache.Add. If
+// marshal fails, nothing is stored and its error is returned.
+func (tc *TypedCache[T]) Set(key string, v T) error {
+	b, err := tc.marshal(v)
+	if err != nil {
+		return err
+	}
+	tc.cache.Add(key, b)
+	return nil
+}
@@ -0,0 +1,70 @@
+package cache
+
+import "context"
+
+// waiter is the rendezvous for goroutines blocked in WaitFor on one key.
+// arrived is closed when the key is stored.
+type waiter struct {
+	arrived chan struct{}
+	n       int // goroutines waiting
+}
+
+// WaitFor returns the value stored under key, promoting it if stale. If key
+// is not cached, WaitFor blocks until another goroutine stores it or ctx is
+// done, in which case it returns ctx.Err(). Each key waited on costs one
+// bookkeeping record while at least one goroutine waits for it; the record
+// is dropped as soon as the key is stored or its last waiter gives up, so
+// the bookkeeping is bounded by the number of blocked goroutines.
+//
+// A value stored and evicted again before a woken waiter runs is missed; the
+// waiter then goes back to waiting.
+func (c *BoundedCache[V]) WaitFor(ctx context.Context, key string) (V, error) {
+	key = c.foldKey(key)
+	for {
+		val, ok, w := c.getOrWait(key)
+		if ok {
+			return c.copyOut(val), nil
+		}
+
+		select {
+		case <-w.arrived:
+		case <-ctx.Done():
+			c.lock.Lock()
+			if w.n--; w.n == 0 && c.waiters[key] == w {
+				delete(c.waiters, key)
+			}
+			c.lock.Unlock()
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// getOrWait returns the value stored under key, promoting it if stale, or,
+// if key is not cached, registers the caller as one of its waiters.
+func (c *BoundedCache[V]) getOrWait(key string) (val V, ok bool, w *waiter) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if val, ok, _ = c.getLocked(key); ok {
+		return val, true, nil
+	}
+	w = c.waiters[key]
+	if w == nil {
+		if c.waiters == nil {
+			c.waiters = make(map[string]*waiter)
+		}
+		w = &waiter{arrived: make(chan struct{})}
+		c.waiters[key] = w
+	}
+	w.n++
+	return val, false, w
+}
+
+// notifyWaiters wakes the goroutines waiting for key, if any. The caller
+// must hold the write lock and have stored key.
+func (c *BoundedCache[V]) notifyWaiters(key string) {
+	if w, ok := c.waiters[key]; ok {
+		close(w.arrived)
+		delete(c.waiters, key)
+	}
+}
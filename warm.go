@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Warm loads keys with at most concurrency calls to load in flight and Adds
+// every successful result. It returns once all loads have finished. Failed
+// keys are skipped; their errors are joined into the returned error, each
+// prefixed with its key. A concurrency below 1 is treated as 1. Only load
+// runs on other goroutines: the results are Added from the goroutine that
+// called Warm, so it is also safe on a cache from NewUnsafeBoundedCache.
+func (c *BoundedCache[V]) Warm(keys []string, load func(key string) (V, error), concurrency int) error {
+	concurrency = max(concurrency, 1)
+
+	type result struct {
+		key string
+		val V
+		err error
+	}
+	work := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < min(concurrency, len(keys)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				val, err := load(key)
+				results <- result{key, val, err}
+			}
+		}()
+	}
+	go func() {
+		for _, key := range keys {
+			work <- key
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	var errs []error
+	for r := range results {
+		if r.err != nil {
+			errs = append(errs, fmt.Errorf("warm %q: %w", r.key, r.err))
+			continue
+		}
+		c.Add(r.key, r.val)
+	}
+	return errors.Join(errs...)
+}